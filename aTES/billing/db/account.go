@@ -3,19 +3,24 @@ package db
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/google/uuid"
-	pq "github.com/lib/pq"
 	"gorm.io/gorm"
 )
 
 type BillingAccount struct {
 	gorm.Model
-	PublicID       uuid.UUID
-	Role           *Role
-	Balance        int
-	Email          string
-	TransactionLog pq.StringArray `gorm:"type:text[]"`
+	PublicID uuid.UUID
+	Role     *Role
+	Email    string
+	// FrozenAt is set when the account is deleted. Its balance is settled
+	// then and nothing is billed to it afterwards.
+	FrozenAt *time.Time
+}
+
+func (acc *BillingAccount) Frozen() bool {
+	return acc.FrozenAt != nil
 }
 
 type Role int
@@ -88,10 +93,8 @@ func (c *Connection) GetAllAccounts() ([]BillingAccount, error) {
 }
 
 type UpdateBillingAccountReq struct {
-	Role           string
-	Balance        *int
-	Email          *string
-	TransactionLog pq.StringArray
+	Role  string
+	Email *string
 }
 
 func (c *Connection) UpdateAccount(id string, req UpdateBillingAccountReq) (*BillingAccount, error) {
@@ -104,18 +107,10 @@ func (c *Connection) UpdateAccount(id string, req UpdateBillingAccountReq) (*Bil
 		acc.Role.UnmarshalText(req.Role)
 	}
 
-	if req.Balance != nil {
-		acc.Balance = *req.Balance
-	}
-
 	if req.Email != nil {
 		acc.Email = *req.Email
 	}
 
-	if req.TransactionLog != nil {
-		acc.TransactionLog = req.TransactionLog
-	}
-
 	return acc, c.SaveAccount(acc)
 }
 
@@ -140,3 +135,56 @@ func (c *Connection) CreateAccount(acc *BillingAccount) error {
 	}
 	return nil
 }
+
+// FreezeAccount settles the account balance in the current cycle and freezes
+// it: money owed to the worker is paid out, a debt is written off by the
+// company. Returns the settling transaction, nil if the balance was zero.
+// Must run inside WithTx.
+func (c *Connection) FreezeAccount(acc *BillingAccount) (*Transaction, error) {
+	// open cycles are locked before the worker account, as postings do, so a
+	// cycle being closed concurrently either sees the account frozen or is
+	// closed before the balance is taken
+	var open []BillingCycle
+	res := c.forShare().Where("status = ?", BillingCycleStatus_Open).Find(&open)
+	if res.Error != nil {
+		return nil, fmt.Errorf("lock open billing_cycles failed: %s", res.Error)
+	}
+
+	bc, err := c.GetCurrentBillingCycle()
+	if err != nil {
+		return nil, err
+	}
+	balance, err := c.LockAccountBalance(acc.PublicID, bc)
+	if err != nil {
+		return nil, err
+	}
+
+	var tx *Transaction
+	if balance != 0 {
+		tx = &Transaction{
+			PublicID:       uuid.New(),
+			OwnerID:        acc.PublicID,
+			BillingCycleID: bc.PublicID,
+			EventTime:      time.Now(),
+		}
+		if balance > 0 {
+			tx.Cost = balance
+			tx.Type = TxType_MakePayment
+			tx.Description = "final payout"
+		} else {
+			tx.Cost = -balance
+			tx.Type = TxType_Add
+			tx.Description = "debt write-off"
+		}
+		if err := c.CreateTransaction(tx); err != nil {
+			return nil, err
+		}
+		if err := c.PostTransaction(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now()
+	acc.FrozenAt = &now
+	return tx, c.SaveAccount(acc)
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestFreezeAccount(t *testing.T) {
+	tests := []struct {
+		name     string
+		fee      int
+		reward   int
+		wantType *TxType
+		wantCost int
+	}{
+		{name: "owed to the worker", fee: 10, reward: 35, wantType: txTypeOf(TxType_MakePayment), wantCost: 25},
+		{name: "worker in debt", fee: 30, reward: 5, wantType: txTypeOf(TxType_Add), wantCost: 25},
+		{name: "settled", fee: 20, reward: 20},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := testConnection(t)
+			bc, err := conn.GetOpenBillingCycle()
+			if err != nil {
+				t.Fatal(err)
+			}
+			acc := &BillingAccount{PublicID: uuid.New(), Email: "worker@example.com"}
+			if err := conn.CreateAccount(acc); err != nil {
+				t.Fatal(err)
+			}
+			postTx(t, conn, bc, acc.PublicID, TxType_Withdraw, tt.fee)
+			postTx(t, conn, bc, acc.PublicID, TxType_Add, tt.reward)
+
+			var tx *Transaction
+			err = conn.WithTx(func(conn Connection) error {
+				tx, err = conn.FreezeAccount(acc)
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			switch {
+			case tt.wantType == nil && tx != nil:
+				t.Errorf("got %s of %d, want no transaction", tx.Type, tx.Cost)
+			case tt.wantType != nil && tx == nil:
+				t.Errorf("got no transaction, want %s of %d", *tt.wantType, tt.wantCost)
+			case tt.wantType != nil && (tx.Type != *tt.wantType || tx.Cost != tt.wantCost || tx.Status != TxStatus_Success):
+				t.Errorf("got %s %s of %d, want %s of %d", tx.Status, tx.Type, tx.Cost, *tt.wantType, tt.wantCost)
+			}
+			if balance := balanceOf(t, conn, LedgerAccountKind_Worker, acc.PublicID); balance != 0 {
+				t.Errorf("balance after freeze = %d, want 0", balance)
+			}
+
+			stored, err := conn.GetAccount(acc.PublicID.String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !stored.Frozen() {
+				t.Error("account is not frozen")
+			}
+		})
+	}
+}
+
+func txTypeOf(t TxType) *TxType {
+	return &t
+}
@@ -1,59 +1,229 @@
 package db
 
 import (
+	"errors"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
-	pq "github.com/lib/pq"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+const BillingCycleDuration = time.Hour * 24
+
 type BillingCycle struct {
 	gorm.Model
-	PublicID       uuid.UUID
-	DaySum         int
-	TransactionLog pq.StringArray `gorm:"type:text[]"`
+	PublicID  uuid.UUID          `json:"public_id"`
+	StartedAt time.Time          `gorm:"uniqueIndex" json:"started_at"`
+	EndedAt   time.Time          `json:"ended_at"`
+	ClosedAt  *time.Time         `json:"closed_at"`
+	Status    BillingCycleStatus `json:"status"`
+	Total     int                `json:"total"`
+}
+
+type BillingCycleStatus int
+
+const (
+	BillingCycleStatus_Open   BillingCycleStatus = 0
+	BillingCycleStatus_Closed BillingCycleStatus = 1
+)
+
+func (status BillingCycleStatus) String() string {
+	switch status {
+	case BillingCycleStatus_Closed:
+		return "closed"
+	default:
+		return "open"
+	}
+}
+
+func newBillingCycle(start time.Time) *BillingCycle {
+	return &BillingCycle{
+		PublicID:  uuid.New(),
+		StartedAt: start,
+		EndedAt:   start.Add(BillingCycleDuration),
+		Status:    BillingCycleStatus_Open,
+	}
 }
 
 func (c *Connection) GetBillingCycle(id string) (*BillingCycle, error) {
+	return getBillingCycle(c.DB, id)
+}
+
+func getBillingCycle(db *gorm.DB, id string) (*BillingCycle, error) {
 	uid, err := uuid.Parse(id)
 	if err != nil {
 		return nil, fmt.Errorf("parse id failed: %w", err)
 	}
 	var bc BillingCycle
-	res := c.Where(&BillingCycle{PublicID: uid}).First(&bc)
+	res := db.Where(&BillingCycle{PublicID: uid}).First(&bc)
 	if res.Error != nil {
-		return nil, fmt.Errorf("get billing_cycle failed: %w", err)
+		return nil, fmt.Errorf("get billing_cycle failed: %w", res.Error)
+	}
+	return &bc, nil
+}
+
+// GetOpenBillingCycle returns the cycle that currently accepts transactions,
+// opening the first one if billing has never run before.
+func (c *Connection) GetOpenBillingCycle() (*BillingCycle, error) {
+	var bc BillingCycle
+	res := c.Where("status = ?", BillingCycleStatus_Open).Order("started_at").First(&bc)
+	if res.Error != nil {
+		if !errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("get open billing_cycle failed: %s", res.Error)
+		}
+
+		return c.ensureBillingCycle(time.Now().Truncate(BillingCycleDuration))
+	}
+
+	// cycles created before lifecycle tracking have no period set
+	if bc.StartedAt.IsZero() {
+		bc.StartedAt = bc.CreatedAt.Truncate(BillingCycleDuration)
+		bc.EndedAt = bc.StartedAt.Add(BillingCycleDuration)
+		if err := c.SaveBillingCycle(&bc); err != nil {
+			return nil, err
+		}
 	}
 	return &bc, nil
 }
 
 func (c *Connection) GetAllBillingCycles() ([]BillingCycle, error) {
 	allBillingCycles := []BillingCycle{}
-	res := c.Find(&allBillingCycles)
+	res := c.Order("started_at").Find(&allBillingCycles)
 	if res.Error != nil {
 		return nil, fmt.Errorf("get all billing_cycle failed: %s", res.Error)
 	}
 	return allBillingCycles, nil
 }
 
-type UpdateBillingCycleReq struct {
-	DaySum         *int
-	TransactionLog pq.StringArray
+// GetBillingCyclesByDay returns the cycles started during the given day.
+func (c *Connection) GetBillingCyclesByDay(day time.Time) ([]BillingCycle, error) {
+	day = day.Truncate(BillingCycleDuration)
+
+	bcs := []BillingCycle{}
+	res := c.Where("started_at >= ? AND started_at < ?", day, day.Add(BillingCycleDuration)).
+		Order("started_at").
+		Find(&bcs)
+	if res.Error != nil {
+		return nil, fmt.Errorf("get billing_cycles by day failed: %s", res.Error)
+	}
+	return bcs, nil
+}
+
+// LockBillingCycle reloads bc and keeps any transaction from being posted
+// into it until the surrounding transaction ends.
+func (c *Connection) LockBillingCycle(bc *BillingCycle) error {
+	locked, err := getBillingCycle(c.forUpdate(), bc.PublicID.String())
+	if err != nil {
+		return err
+	}
+	if locked.Status == BillingCycleStatus_Closed {
+		return fmt.Errorf("billing_cycle %s is already closed", bc.PublicID)
+	}
+	*bc = *locked
+	return nil
+}
+
+// CloseBillingCycle freezes the cycle total and makes sure the next cycle,
+// which starts right where the closed one ends, exists.
+// Must run inside WithTx, see LockBillingCycle.
+func (c *Connection) CloseBillingCycle(bc *BillingCycle) (*BillingCycle, error) {
+	if err := c.LockBillingCycle(bc); err != nil {
+		return nil, err
+	}
+
+	total, err := c.GetBillingCyclesSum(bc.PublicID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	bc.Status = BillingCycleStatus_Closed
+	bc.ClosedAt = &now
+	bc.Total = total
+	if err := c.SaveBillingCycle(bc); err != nil {
+		return nil, err
+	}
+
+	return c.ensureBillingCycle(bc.EndedAt)
+}
+
+func (c *Connection) getBillingCycleStartedAt(start time.Time) (*BillingCycle, error) {
+	var bc BillingCycle
+	res := c.Where("started_at = ?", start).Limit(1).Find(&bc)
+	if res.Error != nil {
+		return nil, fmt.Errorf("get billing_cycle failed: %s", res.Error)
+	}
+	return &bc, nil
 }
 
-func (c *Connection) UpdateBillingCycle(id string, req UpdateBillingCycleReq) (*BillingCycle, error) {
-	t, err := c.GetBillingCycle(id)
+// ensureBillingCycle returns the cycle starting at start, creating it if
+// there is none yet.
+func (c *Connection) ensureBillingCycle(start time.Time) (*BillingCycle, error) {
+	bc, err := c.getBillingCycleStartedAt(start)
 	if err != nil {
 		return nil, err
 	}
-	if req.DaySum != nil {
-		t.DaySum = *req.DaySum
+	if bc.ID != 0 {
+		return bc, nil
 	}
-	if req.TransactionLog != nil {
-		t.TransactionLog = req.TransactionLog
+
+	// a concurrent transaction may be opening the same cycle
+	res := c.Clauses(clause.OnConflict{DoNothing: true}).Create(newBillingCycle(start))
+	if res.Error != nil {
+		return nil, fmt.Errorf("billing_cycle create failed: %s", res.Error)
 	}
-	return t, c.SaveBillingCycle(t)
+	return c.getBillingCycleStartedAt(start)
+}
+
+// GetCurrentBillingCycle returns the cycle of the current day. The previous
+// cycles may still be open while they wait for late events.
+func (c *Connection) GetCurrentBillingCycle() (*BillingCycle, error) {
+	return c.GetBillingCycleAt(time.Now())
+}
+
+// GetBillingCycleAt returns the cycle covering at. Cycles of the current
+// and future days are opened on demand, at is clamped to now so events from
+// producers with a clock ahead go to the current cycle. Past days without a
+// cycle have nothing to bill into, nil is returned for them.
+func (c *Connection) GetBillingCycleAt(at time.Time) (*BillingCycle, error) {
+	now := time.Now()
+	if at.After(now) {
+		at = now
+	}
+
+	var bc BillingCycle
+	res := c.Where("started_at <= ? AND ended_at > ?", at, at).Order("started_at").Limit(1).Find(&bc)
+	if res.Error != nil {
+		return nil, fmt.Errorf("get billing_cycle at %s failed: %s", at, res.Error)
+	}
+	if bc.ID != 0 {
+		return &bc, nil
+	}
+
+	day := at.Truncate(BillingCycleDuration)
+	if day.Before(now.Truncate(BillingCycleDuration)) {
+		return nil, nil
+	}
+	return c.ensureBillingCycle(day)
+}
+
+// GetBillingCycleForEvent picks the cycle for money of an event happened at
+// the given time: the cycle covering it while that one is open, otherwise
+// the current cycle. late reports the latter, such transactions correct the
+// day the event happened.
+func (c *Connection) GetBillingCycleForEvent(at time.Time) (bc *BillingCycle, late bool, err error) {
+	bc, err = c.GetBillingCycleAt(at)
+	if err != nil {
+		return nil, false, err
+	}
+	if bc != nil && bc.Status == BillingCycleStatus_Open {
+		return bc, false, nil
+	}
+
+	bc, err = c.GetCurrentBillingCycle()
+	return bc, true, err
 }
 
 func (c *Connection) SaveBillingCycle(t *BillingCycle) error {
@@ -65,15 +235,15 @@ func (c *Connection) SaveBillingCycle(t *BillingCycle) error {
 }
 
 func (c *Connection) CreateBillingCycle(bc *BillingCycle) error {
-	lookup := BillingTask{PublicID: bc.PublicID}
-	res := c.Find(&lookup)
+	lookup := BillingCycle{}
+	res := c.Where(&BillingCycle{PublicID: bc.PublicID}).Find(&lookup)
 	if res.Error != nil {
-		return fmt.Errorf("get task failed: %s", res.Error)
+		return fmt.Errorf("get billing_cycle failed: %s", res.Error)
 	}
 	if lookup.ID == 0 {
 		res := c.Create(bc)
 		if res.Error != nil {
-			return fmt.Errorf("task create failed: %s", res.Error)
+			return fmt.Errorf("billing_cycle create failed: %s", res.Error)
 		}
 	}
 	return nil
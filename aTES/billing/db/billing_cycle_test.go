@@ -0,0 +1,128 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func today() time.Time {
+	return time.Now().Truncate(BillingCycleDuration)
+}
+
+func TestEnsureBillingCycle(t *testing.T) {
+	conn := testConnection(t)
+
+	first, err := conn.ensureBillingCycle(today())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := conn.ensureBillingCycle(today())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ID == 0 || first.ID != second.ID {
+		t.Errorf("got cycles %d and %d, want the same one", first.ID, second.ID)
+	}
+
+	if res := conn.Create(newBillingCycle(today())); res.Error == nil {
+		t.Error("a second cycle with the same start was created")
+	}
+}
+
+func TestGetBillingCycleAt(t *testing.T) {
+	conn := testConnection(t)
+
+	bc, err := conn.GetBillingCycleAt(today().Add(-BillingCycleDuration))
+	if err != nil || bc != nil {
+		t.Errorf("past day without a cycle: got %v, %v, want nil", bc, err)
+	}
+
+	current, err := conn.GetBillingCycleAt(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !current.StartedAt.Equal(today()) {
+		t.Errorf("current cycle starts at %s, want %s", current.StartedAt, today())
+	}
+
+	// producers with a clock ahead bill into the current cycle
+	ahead, err := conn.GetBillingCycleAt(time.Now().Add(2 * BillingCycleDuration))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ahead.ID != current.ID {
+		t.Errorf("future event got cycle %s, want the current %s", ahead.StartedAt, current.StartedAt)
+	}
+}
+
+func TestCloseBillingCycle(t *testing.T) {
+	conn := testConnection(t)
+
+	bc, err := conn.ensureBillingCycle(today().Add(-BillingCycleDuration))
+	if err != nil {
+		t.Fatal(err)
+	}
+	postTx(t, conn, bc, uuid.New(), TxType_Withdraw, 20)
+	postTx(t, conn, bc, uuid.New(), TxType_Add, 35)
+
+	var next *BillingCycle
+	err = conn.WithTx(func(conn Connection) error {
+		next, err = conn.CloseBillingCycle(bc)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bc.Status != BillingCycleStatus_Closed || bc.ClosedAt == nil {
+		t.Errorf("cycle is %s, want closed", bc.Status)
+	}
+	// fees charged minus rewards paid
+	if bc.Total != -15 {
+		t.Errorf("total = %d, want -15", bc.Total)
+	}
+	if !next.StartedAt.Equal(bc.EndedAt) || next.Status != BillingCycleStatus_Open {
+		t.Errorf("next cycle %s starts at %s, want an open one at %s", next.Status, next.StartedAt, bc.EndedAt)
+	}
+
+	if _, err := conn.CloseBillingCycle(bc); err == nil {
+		t.Error("closed cycle closed again")
+	}
+	tx := &Transaction{PublicID: uuid.New(), OwnerID: uuid.New(), BillingCycleID: bc.PublicID, Cost: 1, Type: TxType_Add}
+	if err := conn.PostTransaction(tx); err == nil {
+		t.Error("transaction posted into a closed cycle")
+	}
+}
+
+func TestGetBillingCycleForEvent(t *testing.T) {
+	conn := testConnection(t)
+
+	yesterday, err := conn.ensureBillingCycle(today().Add(-BillingCycleDuration))
+	if err != nil {
+		t.Fatal(err)
+	}
+	happened := yesterday.StartedAt.Add(time.Hour)
+
+	// the cycle of the event waits for late events until it is closed
+	bc, late, err := conn.GetBillingCycleForEvent(happened)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bc.ID != yesterday.ID || late {
+		t.Errorf("got cycle %s, late %v, want %s on time", bc.StartedAt, late, yesterday.StartedAt)
+	}
+
+	yesterday.Status = BillingCycleStatus_Closed
+	if err := conn.SaveBillingCycle(yesterday); err != nil {
+		t.Fatal(err)
+	}
+	bc, late, err = conn.GetBillingCycleForEvent(happened)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bc.StartedAt.Equal(today()) || !late {
+		t.Errorf("got cycle %s, late %v, want the current one %s, late", bc.StartedAt, late, today())
+	}
+}
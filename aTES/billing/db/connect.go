@@ -2,10 +2,14 @@ package db
 
 import (
 	"fmt"
+	"messaging/deadletter"
+	"messaging/inbox"
+	"messaging/outbox"
 
 	_ "github.com/lib/pq"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Connection struct {
@@ -35,7 +39,35 @@ func Connect() Connection {
 	fmt.Println(db.AutoMigrate(&BillingAccount{}))
 	fmt.Println(db.AutoMigrate(&BillingCycle{}))
 	fmt.Println(db.AutoMigrate(&BillingTask{}))
+	fmt.Println(db.AutoMigrate(&LedgerAccount{}))
+	fmt.Println(db.AutoMigrate(&LedgerEntry{}))
+	fmt.Println(db.AutoMigrate(&outbox.Message{}))
+	fmt.Println(db.AutoMigrate(&inbox.ProcessedEvent{}))
+	fmt.Println(db.AutoMigrate(&deadletter.DeadLetter{}))
+	fmt.Println(db.AutoMigrate(&PricingPolicy{}))
+
+	conn := Connection{db}
+	fmt.Println(conn.migrateBalances())
 	fmt.Println("Successfully connected!")
 
-	return Connection{db}
+	return conn
+}
+
+// WithTx runs fn in a single database transaction. Any error returned by fn
+// (or a panic) rolls back everything fn did.
+func (c *Connection) WithTx(fn func(conn Connection) error) error {
+	return c.DB.Transaction(func(tx *gorm.DB) error {
+		return fn(Connection{tx})
+	})
+}
+
+// forUpdate locks the selected rows until the surrounding transaction ends.
+func (c *Connection) forUpdate() *gorm.DB {
+	return c.Clauses(clause.Locking{Strength: "UPDATE"})
+}
+
+// forShare prevents the selected rows from being changed until the
+// surrounding transaction ends, without blocking other readers.
+func (c *Connection) forShare() *gorm.DB {
+	return c.Clauses(clause.Locking{Strength: "SHARE"})
 }
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"messaging/deadletter"
+	"messaging/inbox"
+	"messaging/outbox"
+	"testing"
+
+	"github.com/google/uuid"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// testConnection opens a private in-memory database with the billing schema.
+func testConnection(t *testing.T) Connection {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	err = db.AutoMigrate(&Transaction{}, &BillingAccount{}, &BillingCycle{}, &BillingTask{},
+		&LedgerAccount{}, &LedgerEntry{}, &outbox.Message{}, &inbox.ProcessedEvent{},
+		&deadletter.DeadLetter{}, &PricingPolicy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Connection{db}
+}
+
+func TestWithTxRollsBack(t *testing.T) {
+	conn := testConnection(t)
+	bc, err := conn.GetOpenBillingCycle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fail := errors.New("publish failed")
+	apply := func(conn Connection) error {
+		tx := &Transaction{PublicID: uuid.New(), OwnerID: uuid.New(), BillingCycleID: bc.PublicID, Type: TxType_Add, Cost: 40}
+		if err := conn.CreateTransaction(tx); err != nil {
+			return err
+		}
+		return conn.PostTransaction(tx)
+	}
+
+	tests := []struct {
+		name string
+		fn   func(conn Connection) error
+	}{
+		{name: "error", fn: func(conn Connection) error {
+			if err := apply(conn); err != nil {
+				return err
+			}
+			return fail
+		}},
+		{name: "panic", fn: func(conn Connection) error {
+			if err := apply(conn); err != nil {
+				return err
+			}
+			panic(fail)
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			func() {
+				defer func() { recover() }()
+				if err := conn.WithTx(tt.fn); err != fail {
+					t.Errorf("got %v, want the error of fn", err)
+				}
+			}()
+
+			var txs, entries int64
+			conn.Model(&Transaction{}).Count(&txs)
+			conn.Model(&LedgerEntry{}).Count(&entries)
+			if txs != 0 || entries != 0 {
+				t.Errorf("%d transactions and %d ledger entries are left", txs, entries)
+			}
+		})
+	}
+}
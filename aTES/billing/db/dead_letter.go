@@ -0,0 +1,7 @@
+package db
+
+import "messaging/deadletter"
+
+func (c *Connection) GetFailedDeadLetters() ([]deadletter.DeadLetter, error) {
+	return deadletter.GetFailed(c.DB)
+}
@@ -0,0 +1,14 @@
+package db
+
+import "messaging/inbox"
+
+// MarkEventProcessed records the event in the inbox and reports whether this
+// is the first time it is seen. A concurrent transaction marking the same
+// event blocks until the first one ends.
+func (c *Connection) MarkEventProcessed(eventID, eventName, topic string) (bool, error) {
+	return inbox.MarkProcessed(c.DB, eventID, eventName, topic)
+}
+
+func (c *Connection) IsEventProcessed(eventID string) (bool, error) {
+	return inbox.IsProcessed(c.DB, eventID)
+}
@@ -0,0 +1,239 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrLedgerEntryImmutable = errors.New("ledger entries are immutable")
+
+type LedgerAccount struct {
+	gorm.Model
+	PublicID uuid.UUID         `gorm:"uniqueIndex" json:"public_id"`
+	Kind     LedgerAccountKind `gorm:"uniqueIndex:idx_ledger_account_owner" json:"kind"`
+	OwnerID  uuid.UUID         `gorm:"uniqueIndex:idx_ledger_account_owner" json:"owner_id"`
+}
+
+type LedgerAccountKind int
+
+const (
+	LedgerAccountKind_Worker  LedgerAccountKind = 0
+	LedgerAccountKind_Company LedgerAccountKind = 1
+	LedgerAccountKind_Payout  LedgerAccountKind = 2
+	// LedgerAccountKind_Opening is the counterpart of the balances accounts
+	// had before the ledger, see migrateBalances.
+	LedgerAccountKind_Opening LedgerAccountKind = 3
+)
+
+func (kind LedgerAccountKind) String() string {
+	switch kind {
+	case LedgerAccountKind_Company:
+		return "company"
+	case LedgerAccountKind_Payout:
+		return "payout"
+	case LedgerAccountKind_Opening:
+		return "opening"
+	default:
+		return "worker"
+	}
+}
+
+// LedgerEntry moves Amount from the debit account to the credit account, so
+// the sum over all ledger accounts is always zero. Entries are never changed,
+// balances are only computed from them.
+type LedgerEntry struct {
+	ID              uint      `gorm:"primarykey" json:"-"`
+	CreatedAt       time.Time `json:"created_at"`
+	PublicID        uuid.UUID `gorm:"uniqueIndex" json:"public_id"`
+	TransactionID   uuid.UUID `gorm:"index" json:"transaction_id"`
+	BillingCycleID  uuid.UUID `gorm:"index" json:"billing_cycle_id"`
+	DebitAccountID  uuid.UUID `gorm:"index" json:"debit_account_id"`
+	CreditAccountID uuid.UUID `gorm:"index" json:"credit_account_id"`
+	Amount          int       `json:"amount"`
+}
+
+func (e *LedgerEntry) BeforeUpdate(*gorm.DB) error {
+	return ErrLedgerEntryImmutable
+}
+
+func (e *LedgerEntry) BeforeDelete(*gorm.DB) error {
+	return ErrLedgerEntryImmutable
+}
+
+func (c *Connection) getLedgerAccount(kind LedgerAccountKind, ownerID uuid.UUID) (*LedgerAccount, error) {
+	var acc LedgerAccount
+	res := c.Where("kind = ? AND owner_id = ?", kind, ownerID).First(&acc)
+	if res.Error != nil {
+		if errors.Is(res.Error, gorm.ErrRecordNotFound) {
+			return &acc, nil
+		}
+		return nil, fmt.Errorf("get ledger acc failed: %s", res.Error)
+	}
+	return &acc, nil
+}
+
+func (c *Connection) ensureLedgerAccount(kind LedgerAccountKind, ownerID uuid.UUID) (*LedgerAccount, error) {
+	acc, err := c.getLedgerAccount(kind, ownerID)
+	if err != nil {
+		return nil, err
+	}
+	if acc.ID != 0 {
+		return acc, nil
+	}
+
+	// a concurrent transaction may be creating the same account
+	res := c.Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&LedgerAccount{PublicID: uuid.New(), Kind: kind, OwnerID: ownerID})
+	if res.Error != nil {
+		return nil, fmt.Errorf("ledger acc create failed: %s", res.Error)
+	}
+	return c.getLedgerAccount(kind, ownerID)
+}
+
+// lockLedgerAccount serializes postings to one account, so a balance read
+// under the lock stays valid until the transaction ends.
+func (c *Connection) lockLedgerAccount(acc *LedgerAccount) error {
+	res := c.forUpdate().Where("id = ?", acc.ID).First(acc)
+	if res.Error != nil {
+		return fmt.Errorf("lock ledger acc failed: %s", res.Error)
+	}
+	return nil
+}
+
+// PostTransaction books tx into the ledger. Posting the same tx twice is a no-op.
+// Must run inside WithTx for the row locks to hold.
+func (c *Connection) PostTransaction(tx *Transaction) error {
+	if tx.Cost < 0 {
+		return fmt.Errorf("tx %s has negative cost %d", tx.PublicID, tx.Cost)
+	}
+
+	bc, err := getBillingCycle(c.forShare(), tx.BillingCycleID.String())
+	if err != nil {
+		return err
+	}
+	if bc.Status == BillingCycleStatus_Closed {
+		return fmt.Errorf("billing_cycle %s is closed", bc.PublicID)
+	}
+
+	var posted int64
+	res := c.Model(&LedgerEntry{}).Where("transaction_id = ?", tx.PublicID).Count(&posted)
+	if res.Error != nil {
+		return fmt.Errorf("get ledger entries failed: %s", res.Error)
+	}
+	if posted > 0 {
+		return nil
+	}
+
+	worker, err := c.ensureLedgerAccount(LedgerAccountKind_Worker, tx.OwnerID)
+	if err != nil {
+		return err
+	}
+	if err := c.lockLedgerAccount(worker); err != nil {
+		return err
+	}
+
+	var counterpart *LedgerAccount
+	switch tx.Type {
+	case TxType_Add, TxType_Withdraw:
+		counterpart, err = c.ensureLedgerAccount(LedgerAccountKind_Company, uuid.Nil)
+	case TxType_MakePayment:
+		counterpart, err = c.ensureLedgerAccount(LedgerAccountKind_Payout, uuid.Nil)
+	default:
+		return fmt.Errorf("tx %s has unknown type %d", tx.PublicID, tx.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	entry := &LedgerEntry{
+		PublicID:       uuid.New(),
+		TransactionID:  tx.PublicID,
+		BillingCycleID: tx.BillingCycleID,
+		Amount:         tx.Cost,
+	}
+	if tx.Type == TxType_Add {
+		entry.DebitAccountID, entry.CreditAccountID = counterpart.PublicID, worker.PublicID
+	} else {
+		entry.DebitAccountID, entry.CreditAccountID = worker.PublicID, counterpart.PublicID
+	}
+
+	if entry.Amount > 0 {
+		if res := c.Create(entry); res.Error != nil {
+			return fmt.Errorf("ledger entry create failed: %s", res.Error)
+		}
+	}
+
+	tx.Status = TxStatus_Success
+	return c.SaveTransaction(tx)
+}
+
+// LockAccountBalance returns the worker balance over the cycles up to and
+// including until and keeps it from changing until the surrounding
+// transaction ends. Later cycles may already have entries while until waits
+// for late events, they are not part of its balance.
+func (c *Connection) LockAccountBalance(ownerID uuid.UUID, until *BillingCycle) (int, error) {
+	acc, err := c.ensureLedgerAccount(LedgerAccountKind_Worker, ownerID)
+	if err != nil {
+		return 0, err
+	}
+	if err := c.lockLedgerAccount(acc); err != nil {
+		return 0, err
+	}
+
+	bcIDs := []uuid.UUID{}
+	res := c.Model(&BillingCycle{}).Where("started_at <= ?", until.StartedAt).Pluck("public_id", &bcIDs)
+	if res.Error != nil {
+		return 0, fmt.Errorf("get billing_cycles until %s failed: %s", until.PublicID, res.Error)
+	}
+	return c.ledgerBalance(acc.PublicID, bcIDs)
+}
+
+func (c *Connection) ledgerBalance(accID uuid.UUID, bcIDs []uuid.UUID) (int, error) {
+	var balance int
+	q := c.Model(&LedgerEntry{}).
+		Select("COALESCE(SUM(CASE WHEN credit_account_id = ? THEN amount ELSE -amount END), 0)", accID).
+		Where("(debit_account_id = ? OR credit_account_id = ?)", accID, accID)
+	if bcIDs != nil {
+		q = q.Where("billing_cycle_id IN ?", bcIDs)
+	}
+	if res := q.Scan(&balance); res.Error != nil {
+		return 0, fmt.Errorf("get ledger balance failed: %s", res.Error)
+	}
+	return balance, nil
+}
+
+// GetAccountBalance returns how much the company owes the worker.
+func (c *Connection) GetAccountBalance(ownerID uuid.UUID) (int, error) {
+	acc, err := c.getLedgerAccount(LedgerAccountKind_Worker, ownerID)
+	if err != nil || acc.ID == 0 {
+		return 0, err
+	}
+	return c.ledgerBalance(acc.PublicID, nil)
+}
+
+// GetBillingCyclesSum returns the company income over the given cycles:
+// assignment fees charged minus rewards for completed tasks.
+func (c *Connection) GetBillingCyclesSum(bcIDs ...uuid.UUID) (int, error) {
+	if len(bcIDs) == 0 {
+		return 0, nil
+	}
+	acc, err := c.getLedgerAccount(LedgerAccountKind_Company, uuid.Nil)
+	if err != nil || acc.ID == 0 {
+		return 0, err
+	}
+	return c.ledgerBalance(acc.PublicID, bcIDs)
+}
+
+func (c *Connection) GetTransactionLedgerEntries(txID uuid.UUID) ([]LedgerEntry, error) {
+	all := []LedgerEntry{}
+	res := c.Where("transaction_id = ?", txID).Find(&all)
+	if res.Error != nil {
+		return nil, fmt.Errorf("get ledger entries failed: %s", res.Error)
+	}
+	return all, nil
+}
@@ -0,0 +1,213 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func postTx(t *testing.T, conn Connection, bc *BillingCycle, ownerID uuid.UUID, txType TxType, cost int) *Transaction {
+	t.Helper()
+	tx := &Transaction{
+		PublicID:       uuid.New(),
+		OwnerID:        ownerID,
+		BillingCycleID: bc.PublicID,
+		Cost:           cost,
+		Type:           txType,
+	}
+	if err := conn.CreateTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.PostTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func balanceOf(t *testing.T, conn Connection, kind LedgerAccountKind, ownerID uuid.UUID) int {
+	t.Helper()
+	acc, err := conn.getLedgerAccount(kind, ownerID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	balance, err := conn.ledgerBalance(acc.PublicID, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return balance
+}
+
+func TestPayout(t *testing.T) {
+	conn := testConnection(t)
+	bc, err := conn.GetOpenBillingCycle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	worker := uuid.New()
+	postTx(t, conn, bc, worker, TxType_Withdraw, 20)
+	postTx(t, conn, bc, worker, TxType_Add, 50)
+
+	balance, err := conn.LockAccountBalance(worker, bc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 30 {
+		t.Fatalf("balance before payout = %d, want 30", balance)
+	}
+
+	payout := postTx(t, conn, bc, worker, TxType_MakePayment, balance)
+	if payout.Status != TxStatus_Success {
+		t.Errorf("payout is %s, want succeeded", payout.Status)
+	}
+	if got := balanceOf(t, conn, LedgerAccountKind_Worker, worker); got != 0 {
+		t.Errorf("balance after payout = %d, want 0", got)
+	}
+	if got := balanceOf(t, conn, LedgerAccountKind_Payout, uuid.Nil); got != 30 {
+		t.Errorf("paid out %d, want 30", got)
+	}
+	// paying the worker is not company income
+	if sum, err := conn.GetBillingCyclesSum(bc.PublicID); err != nil || sum != -30 {
+		t.Errorf("cycle sum = %d, %v, want -30", sum, err)
+	}
+}
+
+func TestLedgerBalances(t *testing.T) {
+	conn := testConnection(t)
+	bc, err := conn.GetOpenBillingCycle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice, bob := uuid.New(), uuid.New()
+	postTx(t, conn, bc, alice, TxType_Withdraw, 15)
+	postTx(t, conn, bc, alice, TxType_Add, 40)
+	postTx(t, conn, bc, bob, TxType_Withdraw, 10)
+	postTx(t, conn, bc, bob, TxType_Add, 0)
+
+	if got := balanceOf(t, conn, LedgerAccountKind_Worker, alice); got != 25 {
+		t.Errorf("alice balance = %d, want 25", got)
+	}
+	if got := balanceOf(t, conn, LedgerAccountKind_Worker, bob); got != -10 {
+		t.Errorf("bob balance = %d, want -10", got)
+	}
+	if got := balanceOf(t, conn, LedgerAccountKind_Company, uuid.Nil); got != -15 {
+		t.Errorf("company balance = %d, want -15", got)
+	}
+
+	// every entry moves money between two accounts, so nothing is lost
+	accs := []LedgerAccount{}
+	if err := conn.Find(&accs).Error; err != nil {
+		t.Fatal(err)
+	}
+	total := 0
+	for _, acc := range accs {
+		balance, err := conn.ledgerBalance(acc.PublicID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += balance
+	}
+	if total != 0 {
+		t.Errorf("ledger accounts sum up to %d, want 0", total)
+	}
+
+	// zero cost transactions are recorded without an entry
+	var entries int64
+	conn.Model(&LedgerEntry{}).Count(&entries)
+	if entries != 3 {
+		t.Errorf("got %d ledger entries, want 3", entries)
+	}
+}
+
+func TestPostTransactionTwice(t *testing.T) {
+	conn := testConnection(t)
+	bc, err := conn.GetOpenBillingCycle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	worker := uuid.New()
+	tx := postTx(t, conn, bc, worker, TxType_Add, 40)
+	if err := conn.PostTransaction(tx); err != nil {
+		t.Fatal(err)
+	}
+	if got := balanceOf(t, conn, LedgerAccountKind_Worker, worker); got != 40 {
+		t.Errorf("balance = %d, want 40 after posting twice", got)
+	}
+}
+
+func TestPostTransactionRejects(t *testing.T) {
+	conn := testConnection(t)
+	bc, err := conn.GetOpenBillingCycle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		tx   Transaction
+	}{
+		{name: "negative cost", tx: Transaction{Type: TxType_Add, Cost: -5}},
+		{name: "unknown type", tx: Transaction{Type: TxType_Unknown, Cost: 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := tt.tx
+			tx.PublicID, tx.OwnerID, tx.BillingCycleID = uuid.New(), uuid.New(), bc.PublicID
+			if err := conn.PostTransaction(&tx); err == nil {
+				t.Error("transaction posted")
+			}
+		})
+	}
+}
+
+func TestLedgerEntriesAreImmutable(t *testing.T) {
+	conn := testConnection(t)
+	bc, err := conn.GetOpenBillingCycle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := postTx(t, conn, bc, uuid.New(), TxType_Add, 40)
+
+	entries, err := conn.GetTransactionLedgerEntries(tx.PublicID)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("got %d entries, %v, want 1", len(entries), err)
+	}
+	entry := entries[0]
+	entry.Amount = 400
+	if err := conn.Save(&entry).Error; err != ErrLedgerEntryImmutable {
+		t.Errorf("update: got %v, want ErrLedgerEntryImmutable", err)
+	}
+	if err := conn.Delete(&entry).Error; err != ErrLedgerEntryImmutable {
+		t.Errorf("delete: got %v, want ErrLedgerEntryImmutable", err)
+	}
+}
+
+func TestLockAccountBalanceUntil(t *testing.T) {
+	conn := testConnection(t)
+	yesterday, err := conn.ensureBillingCycle(today().Add(-BillingCycleDuration))
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := conn.GetCurrentBillingCycle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	worker := uuid.New()
+	postTx(t, conn, yesterday, worker, TxType_Add, 40)
+	postTx(t, conn, current, worker, TxType_Add, 7)
+
+	// yesterday waits for late events while today is already billed
+	balance, err := conn.LockAccountBalance(worker, yesterday)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance != 40 {
+		t.Errorf("balance until yesterday = %d, want 40", balance)
+	}
+	if total, _ := conn.GetAccountBalance(worker); total != 47 {
+		t.Errorf("balance = %d, want 47", total)
+	}
+}
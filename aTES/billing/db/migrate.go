@@ -0,0 +1,69 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/google/uuid"
+)
+
+// migrateBalances moves the balances kept on billing accounts before the
+// ledger into it: every account with a balance gets an opening entry in the
+// open billing cycle, then the balance column is dropped. It does nothing
+// once the column is gone.
+func (c *Connection) migrateBalances() error {
+	if !c.Migrator().HasColumn(&BillingAccount{}, "balance") {
+		return nil
+	}
+
+	return c.WithTx(func(conn Connection) error {
+		var balances []struct {
+			PublicID uuid.UUID
+			Balance  int
+		}
+		res := conn.Model(&BillingAccount{}).Select("public_id, balance").
+			Where("balance <> 0").
+			Scan(&balances)
+		if res.Error != nil {
+			return fmt.Errorf("get account balances failed: %s", res.Error)
+		}
+
+		if len(balances) > 0 {
+			bc, err := conn.GetOpenBillingCycle()
+			if err != nil {
+				return err
+			}
+			opening, err := conn.ensureLedgerAccount(LedgerAccountKind_Opening, uuid.Nil)
+			if err != nil {
+				return err
+			}
+
+			for _, b := range balances {
+				worker, err := conn.ensureLedgerAccount(LedgerAccountKind_Worker, b.PublicID)
+				if err != nil {
+					return err
+				}
+
+				entry := &LedgerEntry{
+					PublicID:       uuid.New(),
+					BillingCycleID: bc.PublicID,
+				}
+				// a positive balance is owed to the worker
+				if b.Balance > 0 {
+					entry.DebitAccountID, entry.CreditAccountID = opening.PublicID, worker.PublicID
+					entry.Amount = b.Balance
+				} else {
+					entry.DebitAccountID, entry.CreditAccountID = worker.PublicID, opening.PublicID
+					entry.Amount = -b.Balance
+				}
+				if res := conn.Create(entry); res.Error != nil {
+					return fmt.Errorf("opening ledger entry create failed: %s", res.Error)
+				}
+			}
+		}
+
+		if err := conn.Migrator().DropColumn(&BillingAccount{}, "balance"); err != nil {
+			return fmt.Errorf("drop billing_accounts.balance failed: %s", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// legacyBillingAccount is the account as it was stored before the ledger.
+type legacyBillingAccount struct {
+	gorm.Model
+	PublicID uuid.UUID
+	Role     *Role
+	Balance  int
+	Email    string
+}
+
+func (legacyBillingAccount) TableName() string {
+	return "billing_accounts"
+}
+
+func TestMigrateBalances(t *testing.T) {
+	conn := testConnection(t)
+	if err := conn.Migrator().DropTable(&BillingAccount{}); err != nil {
+		t.Fatal(err)
+	}
+	// an accounts table from before the ledger, with the columns added since
+	if err := conn.AutoMigrate(&legacyBillingAccount{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.AutoMigrate(&BillingAccount{}); err != nil {
+		t.Fatal(err)
+	}
+
+	balances := map[uuid.UUID]int{uuid.New(): 120, uuid.New(): -35, uuid.New(): 0}
+	for id, balance := range balances {
+		acc := &legacyBillingAccount{PublicID: id, Balance: balance, Email: id.String()}
+		if err := conn.Create(acc).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the second run finds the column gone and must not post twice
+	for i := 0; i < 2; i++ {
+		if err := conn.migrateBalances(); err != nil {
+			t.Fatalf("run %d: %s", i+1, err)
+		}
+	}
+
+	if conn.Migrator().HasColumn(&BillingAccount{}, "balance") {
+		t.Error("balance column is not dropped")
+	}
+	for id, want := range balances {
+		got, err := conn.GetAccountBalance(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("balance of %s = %d, want %d", id, got, want)
+		}
+	}
+
+	var entries int64
+	conn.Model(&LedgerEntry{}).Count(&entries)
+	if entries != 2 {
+		t.Errorf("got %d ledger entries, want 2", entries)
+	}
+
+	// opening balances are not company income
+	bc, err := conn.GetOpenBillingCycle()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum, err := conn.GetBillingCyclesSum(bc.PublicID); err != nil || sum != 0 {
+		t.Errorf("cycle sum = %d, %v, want 0", sum, err)
+	}
+}
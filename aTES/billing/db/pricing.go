@@ -0,0 +1,147 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PricingPolicy sets the ranges task costs are picked from, for the tasks
+// priced from EffectiveFrom on. Policies are never changed, a new version is
+// added instead, so every priced task can be traced back to its policy.
+type PricingPolicy struct {
+	gorm.Model
+	Version       int       `gorm:"uniqueIndex" json:"version"`
+	EffectiveFrom time.Time `gorm:"index" json:"effective_from"`
+	// the ranges are inclusive
+	MinAssignCost int       `json:"min_assign_cost"`
+	MaxAssignCost int       `json:"max_assign_cost"`
+	MinDoneCost   int       `json:"min_done_cost"`
+	MaxDoneCost   int       `json:"max_done_cost"`
+	Reason        string    `json:"reason"`
+	CreatedBy     uuid.UUID `json:"created_by"`
+}
+
+// DefaultPricingPolicy is the first policy, it keeps the costs tasks were
+// priced with before policies were introduced.
+var DefaultPricingPolicy = PricingPolicy{
+	MinAssignCost: 10,
+	MaxAssignCost: 20,
+	MinDoneCost:   20,
+	MaxDoneCost:   40,
+	Reason:        "initial policy",
+}
+
+var (
+	ErrBadCostRange    = errors.New("costs must be positive and min must not exceed max")
+	ErrNoPricingReason = errors.New("reason is required")
+	ErrNoPricingPolicy = errors.New("no pricing policy in effect")
+	ErrEffectiveInPast = errors.New("policy can't take effect in the past")
+)
+
+// NewPricingPolicy makes a policy from the accounter input. It may take
+// effect now or later, tasks already priced keep their costs.
+func NewPricingPolicy(minAssign, maxAssign, minDone, maxDone int, effectiveFrom time.Time, reason string, by uuid.UUID) (*PricingPolicy, error) {
+	if minAssign <= 0 || minDone <= 0 || minAssign > maxAssign || minDone > maxDone {
+		return nil, ErrBadCostRange
+	}
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, ErrNoPricingReason
+	}
+	now := time.Now()
+	if effectiveFrom.IsZero() {
+		effectiveFrom = now
+	}
+	if effectiveFrom.Before(now.Add(-time.Minute)) {
+		return nil, ErrEffectiveInPast
+	}
+
+	return &PricingPolicy{
+		EffectiveFrom: effectiveFrom.UTC(),
+		MinAssignCost: minAssign,
+		MaxAssignCost: maxAssign,
+		MinDoneCost:   minDone,
+		MaxDoneCost:   maxDone,
+		Reason:        reason,
+		CreatedBy:     by,
+	}, nil
+}
+
+func (p *PricingPolicy) String() string {
+	return fmt.Sprintf("v%d: assign %d..%d, done %d..%d", p.Version, p.MinAssignCost, p.MaxAssignCost, p.MinDoneCost, p.MaxDoneCost)
+}
+
+// Price picks the task costs. The pick is seeded by the task id and the
+// policy version, so the same task is always priced the same under a policy.
+func (p *PricingPolicy) Price(t *BillingTask) {
+	h := fnv.New64a()
+	h.Write(t.PublicID[:])
+	fmt.Fprintf(h, "v%d", p.Version)
+	r := rand.New(rand.NewSource(int64(h.Sum64())))
+
+	t.AssignCost = p.MinAssignCost + r.Intn(p.MaxAssignCost-p.MinAssignCost+1)
+	t.DoneCost = p.MinDoneCost + r.Intn(p.MaxDoneCost-p.MinDoneCost+1)
+	t.PricingPolicyVersion = p.Version
+	t.PricingReason = fmt.Sprintf("policy %s (%s)", p, p.Reason)
+}
+
+// EnsurePricingPolicy adds the default policy if there is none yet.
+func (c *Connection) EnsurePricingPolicy() error {
+	var count int64
+	if res := c.Model(&PricingPolicy{}).Count(&count); res.Error != nil {
+		return fmt.Errorf("count pricing_policies failed: %s", res.Error)
+	}
+	if count > 0 {
+		return nil
+	}
+
+	p := DefaultPricingPolicy
+	return c.CreatePricingPolicy(&p)
+}
+
+// GetPricingPolicyAt returns the policy in effect at the given time: the one
+// that took effect last, the newest version if several took effect together.
+func (c *Connection) GetPricingPolicyAt(at time.Time) (*PricingPolicy, error) {
+	var p PricingPolicy
+	res := c.Where("effective_from <= ?", at).Order("effective_from DESC, version DESC").Limit(1).Find(&p)
+	if res.Error != nil {
+		return nil, fmt.Errorf("get pricing_policy failed: %s", res.Error)
+	}
+	if p.ID == 0 {
+		return nil, ErrNoPricingPolicy
+	}
+	return &p, nil
+}
+
+// GetPricingPolicies returns the policy history, newest version first.
+func (c *Connection) GetPricingPolicies() ([]PricingPolicy, error) {
+	all := []PricingPolicy{}
+	res := c.Order("version DESC").Find(&all)
+	if res.Error != nil {
+		return nil, fmt.Errorf("get all pricing_policies failed: %s", res.Error)
+	}
+	return all, nil
+}
+
+// CreatePricingPolicy stores p as the next policy version. A concurrent
+// change takes the same version and fails on the unique index.
+func (c *Connection) CreatePricingPolicy(p *PricingPolicy) error {
+	var last int
+	res := c.Model(&PricingPolicy{}).Select("COALESCE(MAX(version), 0)").Scan(&last)
+	if res.Error != nil {
+		return fmt.Errorf("get last pricing_policy version failed: %s", res.Error)
+	}
+
+	p.Version = last + 1
+	if res := c.Create(p); res.Error != nil {
+		return fmt.Errorf("pricing_policy create failed: %s", res.Error)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestNewPricingPolicy(t *testing.T) {
+	tests := []struct {
+		name                                   string
+		minAssign, maxAssign, minDone, maxDone int
+		from                                   time.Time
+		reason                                 string
+		want                                   error
+	}{
+		{name: "valid", minAssign: 5, maxAssign: 5, minDone: 10, maxDone: 30, reason: "cheaper assignments"},
+		{name: "later", minAssign: 5, maxAssign: 10, minDone: 10, maxDone: 30, from: time.Now().Add(time.Hour), reason: "next sprint"},
+		{name: "zero cost", minAssign: 0, maxAssign: 10, minDone: 10, maxDone: 30, reason: "free", want: ErrBadCostRange},
+		{name: "min above max", minAssign: 5, maxAssign: 10, minDone: 40, maxDone: 30, reason: "typo", want: ErrBadCostRange},
+		{name: "blank reason", minAssign: 5, maxAssign: 10, minDone: 10, maxDone: 30, reason: "  ", want: ErrNoPricingReason},
+		{name: "in the past", minAssign: 5, maxAssign: 10, minDone: 10, maxDone: 30, from: time.Now().Add(-time.Hour), reason: "backdated", want: ErrEffectiveInPast},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewPricingPolicy(tt.minAssign, tt.maxAssign, tt.minDone, tt.maxDone, tt.from, tt.reason, uuid.New())
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("got error %v, want %v", err, tt.want)
+			}
+			if err == nil && p.EffectiveFrom.IsZero() {
+				t.Error("policy takes effect at zero time")
+			}
+		})
+	}
+}
+
+func TestPrice(t *testing.T) {
+	p := &PricingPolicy{Version: 2, MinAssignCost: 10, MaxAssignCost: 20, MinDoneCost: 20, MaxDoneCost: 40, Reason: "initial policy"}
+
+	for i := 0; i < 50; i++ {
+		task := &BillingTask{PublicID: uuid.New()}
+		p.Price(task)
+		if task.AssignCost < 10 || task.AssignCost > 20 || task.DoneCost < 20 || task.DoneCost > 40 {
+			t.Fatalf("priced %d/%d, out of %s", task.AssignCost, task.DoneCost, p)
+		}
+		if task.PricingPolicyVersion != 2 || task.PricingReason == "" {
+			t.Fatalf("priced by version %d, reason %q", task.PricingPolicyVersion, task.PricingReason)
+		}
+
+		// a redelivered event prices the task the same
+		again := &BillingTask{PublicID: task.PublicID}
+		p.Price(again)
+		if again.AssignCost != task.AssignCost || again.DoneCost != task.DoneCost {
+			t.Fatalf("priced %d/%d, then %d/%d", task.AssignCost, task.DoneCost, again.AssignCost, again.DoneCost)
+		}
+	}
+}
+
+func TestGetPricingPolicyAt(t *testing.T) {
+	conn := testConnection(t)
+
+	if _, err := conn.GetPricingPolicyAt(time.Now()); !errors.Is(err, ErrNoPricingPolicy) {
+		t.Fatalf("got %v before any policy, want %v", err, ErrNoPricingPolicy)
+	}
+	for i := 0; i < 2; i++ {
+		if err := conn.EnsurePricingPolicy(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now().UTC()
+	for _, p := range []*PricingPolicy{
+		{EffectiveFrom: now.Add(-time.Hour), MinAssignCost: 1, MaxAssignCost: 1, MinDoneCost: 1, MaxDoneCost: 1},
+		{EffectiveFrom: now.Add(-time.Hour), MinAssignCost: 2, MaxAssignCost: 2, MinDoneCost: 2, MaxDoneCost: 2},
+		{EffectiveFrom: now.Add(time.Hour), MinAssignCost: 3, MaxAssignCost: 3, MinDoneCost: 3, MaxDoneCost: 3},
+	} {
+		if err := conn.CreatePricingPolicy(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	all, err := conn.GetPricingPolicies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 4 || all[0].Version != 4 || all[3].Version != 1 {
+		t.Fatalf("got %d policies, want versions 4 to 1", len(all))
+	}
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want int
+	}{
+		{name: "before the changes", at: now.Add(-2 * time.Hour), want: 1},
+		// the newest of the policies taking effect together wins
+		{name: "after the changes", at: now, want: 3},
+		{name: "after the scheduled change", at: now.Add(2 * time.Hour), want: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := conn.GetPricingPolicyAt(tt.at)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Version != tt.want {
+				t.Errorf("got version %d, want %d", p.Version, tt.want)
+			}
+		})
+	}
+}
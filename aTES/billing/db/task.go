@@ -1,23 +1,49 @@
 package db
 
 import (
+	"errors"
 	"fmt"
-	"math/rand"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
+// BillingTask is created by whichever task event comes first and priced
+// right then, the events that come later only fill in the details.
 type BillingTask struct {
 	gorm.Model
-	PublicID    uuid.UUID `json:"public_id"`
+	PublicID    uuid.UUID `gorm:"uniqueIndex" json:"public_id"`
 	OwnerID     uuid.UUID `json:"owner_id"`
 	AssignCost  int       `json:"assign_cost"`
 	DoneCost    int       `json:"done_cost"`
+	Title       string    `json:"title"`
+	JiraID      string    `json:"jira_id"`
 	Description string    `json:"description"`
 	Status      Status    `json:"status"`
 	CloseDay    time.Time `json:"close_day"`
+	// Paid is set once the reward for the completed task is paid.
+	Paid bool `json:"paid"`
+	// Deleted tasks are neither charged for nor rewarded anymore.
+	Deleted bool `json:"deleted"`
+	// PricingPolicyVersion is the policy the costs were picked by and
+	// PricingReason how they were picked.
+	PricingPolicyVersion int    `json:"pricing_policy_version"`
+	PricingReason        string `json:"pricing_reason"`
+}
+
+// Name is how the task is shown in statements: "[JIRA-42] Title", or just
+// the title for tasks created before jira ids were introduced.
+func (t *BillingTask) Name() string {
+	name := t.Title
+	if name == "" {
+		name = t.Description
+	}
+	if t.JiraID != "" {
+		return fmt.Sprintf("[%s] %s", t.JiraID, name)
+	}
+	return name
 }
 
 type Status int
@@ -51,15 +77,56 @@ func (status *Status) UnmarshalText(s string) {
 }
 
 func (c *Connection) GetBillingTask(id string) (*BillingTask, error) {
+	return getBillingTask(c.DB, id)
+}
+
+// GetBillingTaskForUpdate locks the task row until the surrounding
+// transaction ends, so concurrent events for one task are applied in turn.
+func (c *Connection) GetBillingTaskForUpdate(id string) (*BillingTask, error) {
+	return getBillingTask(c.forUpdate(), id)
+}
+
+// GetOrCreateBillingTaskForUpdate locks the task like GetBillingTaskForUpdate.
+// A task no event has been seen for yet is created as a placeholder priced
+// on first sight by the policy in effect, so events coming before
+// TaskCreated are not lost. priced reports whether the task was priced now.
+func (c *Connection) GetOrCreateBillingTaskForUpdate(id string) (task *BillingTask, priced bool, err error) {
+	task, err = getBillingTask(c.forUpdate(), id)
+	if err == nil || !errors.Is(err, gorm.ErrRecordNotFound) {
+		return task, false, err
+	}
+
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, false, fmt.Errorf("parse id failed: %w", err)
+	}
+	policy, err := c.GetPricingPolicyAt(time.Now())
+	if err != nil {
+		return nil, false, err
+	}
+
+	placeholder := &BillingTask{PublicID: uid}
+	policy.Price(placeholder)
+	// the task may already exist or be created by a concurrent transaction
+	res := c.Clauses(clause.OnConflict{Columns: []clause.Column{{Name: "public_id"}}, DoNothing: true}).
+		Create(placeholder)
+	if res.Error != nil {
+		return nil, false, fmt.Errorf("task create failed: %s", res.Error)
+	}
+	task, err = getBillingTask(c.forUpdate(), id)
+	return task, res.RowsAffected > 0, err
+}
+
+func getBillingTask(db *gorm.DB, id string) (*BillingTask, error) {
 	uid, err := uuid.Parse(id)
 	if err != nil {
 		return nil, fmt.Errorf("parse id failed: %w", err)
 	}
 
 	var task BillingTask
-	res := c.Where(&BillingTask{PublicID: uid}).First(&task)
+	res := db.Where(&BillingTask{PublicID: uid}).First(&task)
 	if res.Error != nil {
-		return nil, fmt.Errorf("get task failed: %w", err)
+		return nil, fmt.Errorf("get task failed: %w", res.Error)
 	}
 
 	return &task, nil
@@ -116,8 +183,8 @@ func (c *Connection) SaveBillingTask(t *BillingTask) error {
 }
 
 func (c *Connection) CreateBillingTask(t *BillingTask) error {
-	lookup := BillingTask{PublicID: t.PublicID}
-	res := c.Find(&lookup)
+	lookup := BillingTask{}
+	res := c.Where(&BillingTask{PublicID: t.PublicID}).Find(&lookup)
 	if res.Error != nil {
 		return fmt.Errorf("get task failed: %s", res.Error)
 	}
@@ -130,18 +197,6 @@ func (c *Connection) CreateBillingTask(t *BillingTask) error {
 	return nil
 }
 
-const (
-	maxAssignCost = 20
-	minAssignCost = 10
-	maxDoneCost   = 40
-	minDoneCost   = 20
-)
-
-func (t *BillingTask) SetCosts() {
-	t.AssignCost = rand.Intn(maxAssignCost-minAssignCost) + minAssignCost
-	t.DoneCost = rand.Intn(maxDoneCost-minDoneCost) + minDoneCost
-}
-
 func (c *Connection) GetDoneBillingTasks() ([]BillingTask, error) {
 	all := []BillingTask{}
 	res := c.Where("status = ?", Status_Done).Find(&all)
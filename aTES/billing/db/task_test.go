@@ -0,0 +1,53 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestBillingTaskName(t *testing.T) {
+	tests := []struct {
+		task BillingTask
+		want string
+	}{
+		{task: BillingTask{Title: "fix login", JiraID: "POPUG-42"}, want: "[POPUG-42] fix login"},
+		{task: BillingTask{Title: "fix login"}, want: "fix login"},
+		// tasks consumed from v1 events kept everything in the description
+		{task: BillingTask{Description: "[POPUG-7] old task"}, want: "[POPUG-7] old task"},
+	}
+	for _, tt := range tests {
+		if got := tt.task.Name(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestGetOrCreateBillingTaskForUpdate(t *testing.T) {
+	conn := testConnection(t)
+	if err := conn.EnsurePricingPolicy(); err != nil {
+		t.Fatal(err)
+	}
+	id := uuid.New()
+
+	// TaskAssigned comes before TaskCreated
+	placeholder, priced, err := conn.GetOrCreateBillingTaskForUpdate(id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !priced || placeholder.PublicID != id || placeholder.AssignCost == 0 || placeholder.PricingPolicyVersion != 1 {
+		t.Fatalf("got %+v, priced %v, want a placeholder priced by policy 1", placeholder, priced)
+	}
+
+	task, priced, err := conn.GetOrCreateBillingTaskForUpdate(id.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if priced || task.ID != placeholder.ID || task.AssignCost != placeholder.AssignCost {
+		t.Errorf("second event got task %d priced %v, want task %d as it was", task.ID, priced, placeholder.ID)
+	}
+
+	if _, _, err := conn.GetOrCreateBillingTaskForUpdate("not-a-uuid"); err == nil {
+		t.Error("task created for a bad id")
+	}
+}
@@ -2,6 +2,7 @@ package db
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
@@ -9,12 +10,21 @@ import (
 
 type Transaction struct {
 	gorm.Model
-	PublicID    uuid.UUID `json:"public_id"`
-	OwnerID     uuid.UUID `json:"owner_id"`
-	Cost        int       `json:"cost"`
-	Type        TxType    `json:"type"`
-	Status      TxStatus  `json:"status"`
-	Description string    `json:"description"`
+	PublicID       uuid.UUID `json:"public_id"`
+	OwnerID        uuid.UUID `json:"owner_id"`
+	BillingCycleID uuid.UUID `json:"billing_cycle_id"`
+	Cost           int       `json:"cost"`
+	Type           TxType    `json:"type"`
+	Status         TxStatus  `json:"status"`
+	Description    string    `json:"description"`
+	// EventTime is when the event the transaction is made for happened.
+	EventTime time.Time `json:"event_time"`
+	// TaskID is the task the transaction is made for, if any.
+	TaskID uuid.UUID `gorm:"index" json:"task_id"`
+	// CorrectsDay is set on corrections: transactions for events that came
+	// after the cycle of their day was closed. They are billed in the current
+	// cycle instead.
+	CorrectsDay *time.Time `json:"corrects_day,omitempty"`
 }
 
 type TxType int
@@ -122,18 +132,52 @@ func (c *Connection) SaveTransaction(t *Transaction) error {
 	return nil
 }
 
-func (c *Connection) GetAllUserTransactions(uids []string) ([]Transaction, error) {
+func (c *Connection) GetAccountTransactions(ownerID uuid.UUID) ([]Transaction, error) {
 	allTransactions := []Transaction{}
-	res := c.Where("public_id IN ?", uids).Find(&allTransactions)
+	res := c.Where("owner_id = ?", ownerID).Order("created_at").Find(&allTransactions)
 	if res.Error != nil {
 		return nil, fmt.Errorf("get all txes by id failed: %s", res.Error)
 	}
 	return allTransactions, nil
 }
 
+func (c *Connection) GetBillingCycleTransactions(bcIDs ...uuid.UUID) ([]Transaction, error) {
+	allTransactions := []Transaction{}
+	res := c.Where("billing_cycle_id IN ?", bcIDs).Order("created_at").Find(&allTransactions)
+	if res.Error != nil {
+		return nil, fmt.Errorf("get all txes by billing_cycle failed: %s", res.Error)
+	}
+	return allTransactions, nil
+}
+
+// GetTaskTransactions returns the transactions made for the task in the
+// given billing cycles, or in every cycle when none is given, oldest first.
+func (c *Connection) GetTaskTransactions(taskID uuid.UUID, bcIDs ...uuid.UUID) ([]Transaction, error) {
+	allTransactions := []Transaction{}
+	q := c.Where("task_id = ?", taskID)
+	if len(bcIDs) > 0 {
+		q = q.Where("billing_cycle_id IN ?", bcIDs)
+	}
+	res := q.Order("created_at").Find(&allTransactions)
+	if res.Error != nil {
+		return nil, fmt.Errorf("get all txes by task failed: %s", res.Error)
+	}
+	return allTransactions, nil
+}
+
+// CreateTransaction stores tx in the given billing cycle or, when none is
+// set, in the current one.
 func (c *Connection) CreateTransaction(tx *Transaction) error {
-	lookup := Transaction{PublicID: tx.PublicID}
-	res := c.Find(&lookup)
+	if tx.BillingCycleID == uuid.Nil {
+		bc, err := c.GetCurrentBillingCycle()
+		if err != nil {
+			return err
+		}
+		tx.BillingCycleID = bc.PublicID
+	}
+
+	lookup := Transaction{}
+	res := c.Where(&Transaction{PublicID: tx.PublicID}).Find(&lookup)
 	if res.Error != nil {
 		return fmt.Errorf("get Transaction failed: %s", res.Error)
 	}
@@ -0,0 +1,53 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestGetTaskTransactions(t *testing.T) {
+	conn := testConnection(t)
+
+	yesterday, err := conn.ensureBillingCycle(today().Add(-BillingCycleDuration))
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := conn.GetCurrentBillingCycle()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	taskID := uuid.New()
+	for _, bc := range []*BillingCycle{yesterday, current, current} {
+		tx := &Transaction{PublicID: uuid.New(), TaskID: taskID, BillingCycleID: bc.PublicID, Type: TxType_Withdraw}
+		if err := conn.CreateTransaction(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	other := &Transaction{PublicID: uuid.New(), TaskID: uuid.New(), BillingCycleID: current.PublicID}
+	if err := conn.CreateTransaction(other); err != nil {
+		t.Fatal(err)
+	}
+
+	all, err := conn.GetTaskTransactions(taskID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 {
+		t.Errorf("got %d transactions of the task, want 3", len(all))
+	}
+
+	inCurrent, err := conn.GetTaskTransactions(taskID, current.PublicID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inCurrent) != 2 {
+		t.Errorf("got %d transactions of the task in the current cycle, want 2", len(inCurrent))
+	}
+	for _, tx := range inCurrent {
+		if tx.BillingCycleID != current.PublicID {
+			t.Errorf("tx %s is from cycle %s", tx.PublicID, tx.BillingCycleID)
+		}
+	}
+}
@@ -4,57 +4,91 @@ import (
 	"billing/db"
 	"billing/kafka/producer"
 	"encoding/json"
+	"events"
 	"fmt"
 	"log"
+	"messaging/router"
+	"messaging/validation"
+	"messaging/versioning"
 	"os"
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/dkolistratova/eventschemaregistry"
 	"github.com/google/uuid"
 )
 
 const (
-	AccountEventsTopic = "accounts"
-	AccountCUDsTopic   = "accounts-stream"
-
-	AccountCreatedEvt = "AccountCreated"
-	AccountUpdatedEvt = "AccountUpdated"
-	AccountDeletedEvt = "AccountDeleted"
-
-	AccountRoleChangedEvt = "AccountRoleChanged"
+	// RetryTopic holds events waiting for another processing attempt and
+	// DeadLetterTopic the ones that failed every attempt. QuarantineTopic
+	// holds events that don't match their schema, they are never applied.
+	RetryTopic      = "billing-retry"
+	DeadLetterTopic = "billing-dead-letters"
+	QuarantineTopic = "billing-quarantine"
+)
 
-	TaskEventsTopic = "tasks"
-	TaskCUDsTopic   = "tasks-stream"
+// Topics are the topics this consumer reads from or reroutes failed events to.
+var Topics = []string{
+	events.AccountEventsTopic, events.AccountCUDsTopic,
+	events.TaskEventsTopic, events.TaskCUDsTopic,
+	RetryTopic, DeadLetterTopic, QuarantineTopic,
+}
 
-	TaskCreatedEvt = "TaskCreated"
-	TaskUpdatedEvt = "TaskUpdated"
-	TaskDeletedEvt = "TaskDeleted"
+// ErrInvalidEvent marks events that can never be applied. They are
+// quarantined at once, as retrying them can't help.
+var ErrInvalidEvent = router.ErrInvalidEvent
 
-	TaskAssignedEvt  = "TaskAssigned"
-	TaskCompletedEvt = "TaskCompleted"
-)
+// Config sizes the worker pool and sets how many times a failing event is
+// processed.
+type Config = router.Config
 
 type Consumer struct {
 	DBConn              db.Connection
 	RoleChangesNotifyer chan uuid.UUID
-	Producer            producer.Producer
+	Producer            *producer.Producer
+
+	processors map[string]processor
+	router     *router.Router
+	validator  *eventschemaregistry.Validator
 }
 
-func NewConsumer(dbConn db.Connection, roleChangesNotifyer chan uuid.UUID, p producer.Producer) *Consumer {
-	return &Consumer{
+func NewConsumer(dbConn db.Connection, roleChangesNotifyer chan uuid.UUID, p *producer.Producer, cfg Config) *Consumer {
+	c := &Consumer{
 		DBConn:              dbConn,
 		RoleChangesNotifyer: roleChangesNotifyer,
 		Producer:            p,
+		validator:           eventschemaregistry.NewValidator("/app/event_schema_registry/schemas"),
+	}
+	c.processors = map[string]processor{
+		events.AccountEventsTopic: c.processAccountEvts,
+		events.AccountCUDsTopic:   c.processAccountsCUDs,
+		events.TaskCUDsTopic:      c.processTaskCUDs,
+		events.TaskEventsTopic:    c.processTaskEvts,
+	}
+
+	handlers := map[string]router.Handler{}
+	for topic, proc := range c.processors {
+		proc := proc
+		handlers[topic] = func(msg *kafka.Message) error {
+			return c.handle(proc, msg)
+		}
 	}
+	c.router = router.New(dbConn.DB, router.Topics{
+		Retry:      RetryTopic,
+		DeadLetter: DeadLetterTopic,
+		Quarantine: QuarantineTopic,
+	}, handlers, cfg)
+	return c
 }
 
 func (consumer *Consumer) Run() {
 	conf := kafka.ConfigMap{
-		"bootstrap.servers":     "broker:29092",
-		"broker.address.family": "v4",
-		"group.id":              2,
-		"session.timeout.ms":    6000,
-		"auto.offset.reset":     "earliest",
+		"bootstrap.servers":        "broker:29092",
+		"broker.address.family":    "v4",
+		"group.id":                 2,
+		"session.timeout.ms":       6000,
+		"auto.offset.reset":        "earliest",
+		"enable.auto.offset.store": false,
 	}
 	c, err := kafka.NewConsumer(&conf)
 	if err != nil {
@@ -64,51 +98,90 @@ func (consumer *Consumer) Run() {
 
 	defer c.Close()
 
-	topicProcessors := map[string]func(msg *kafka.Message) error{
-		AccountEventsTopic: consumer.processAccountEvts,
-		AccountCUDsTopic:   consumer.processAccountsCUDs,
-		TaskCUDsTopic:      consumer.processTaskCUDs,
-		TaskEventsTopic:    consumer.processTaskEvts,
-	}
+	defer consumer.router.Close()
 
-	var topics []string
-	for k := range topicProcessors {
+	topics := []string{}
+	for k := range consumer.processors {
 		topics = append(topics, k)
 	}
-
 	if err := c.SubscribeTopics(topics, nil); err != nil {
 		fmt.Println(err)
 	}
 
-	for {
-		ev, err := c.ReadMessage(time.Second * 10)
-		if err != nil {
-			continue
-		}
-		fmt.Printf("Consumed event from topic %s: key = %-10s value = %s\n",
-			*ev.TopicPartition.Topic, string(ev.Key), string(ev.Value))
-		if processor, ok := topicProcessors[*ev.TopicPartition.Topic]; ok {
-			go func() {
-				fmt.Println(processor(ev))
-			}()
-		}
+	go consumer.router.RunRetries()
+	consumer.router.Consume(c)
+}
+
+// Redrive processes a dead-lettered event again with payload replacing the
+// original one, see Router.Redrive.
+func (consumer *Consumer) Redrive(id string, payload []byte) error {
+	return consumer.router.Redrive(id, payload)
+}
+
+// eventTime is when evt happened, events with an unreadable time are invalid.
+func eventTime(evt *events.Event) (time.Time, error) {
+	at, err := evt.Time()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %s", ErrInvalidEvent, err)
 	}
+	return at, nil
 }
 
-func (c *Consumer) processAccountEvts(msg *kafka.Message) error {
-	var payload Event
-	if err := json.Unmarshal(msg.Value, &payload); err != nil {
+// processor handles an event already upcast to the latest version.
+type processor func(conn db.Connection, evt *events.Event) error
+
+// handle applies the event atomically: every change made by the processor,
+// including the events it publishes and the inbox record, is committed or
+// none of them is. Events already in the inbox are skipped.
+func (c *Consumer) handle(processor processor, msg *kafka.Message) error {
+	var evt events.Event
+	if err := json.Unmarshal(msg.Value, &evt); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidEvent, err)
+	}
+	if !versioning.IsPreferredCopy(msg, evt.EventName) {
+		fmt.Println("skipping copy of", &evt)
+		return nil
+	}
+	if err := validation.Validate(c.validator, &evt, msg.Value); err != nil {
+		return err
+	}
+	if err := versioning.Upcast(&evt); err != nil {
 		return err
 	}
 
-	switch payload.EventName {
-	case AccountRoleChangedEvt:
-		var data RoleChangePayload
-		if err := json.Unmarshal([]byte(msg.Value), &data); err != nil {
+	return c.DBConn.WithTx(func(conn db.Connection) error {
+		if evt.EventID == "" {
+			log.Println("event without event_id, can't deduplicate", &evt)
+		} else {
+			first, err := conn.MarkEventProcessed(evt.EventID, evt.EventName, *msg.TopicPartition.Topic)
+			if err != nil {
+				return err
+			}
+			if !first {
+				fmt.Println("skipping already processed event", &evt)
+				return nil
+			}
+		}
+
+		fmt.Println("handling", &evt)
+		return processor(conn, &evt)
+	})
+}
+
+// Seen reports whether the event has already been processed.
+func (c *Consumer) Seen(eventID string) (bool, error) {
+	return c.DBConn.IsEventProcessed(eventID)
+}
+
+func (c *Consumer) processAccountEvts(conn db.Connection, evt *events.Event) error {
+	switch evt.EventName {
+	case events.AccountRoleChangedEvt:
+		var data events.AccountRoleChangedV1
+		if err := json.Unmarshal(evt.Data, &data); err != nil {
 			return err
 		}
 
-		acc, err := c.DBConn.GetAccount(data.Data.PublicID)
+		acc, err := conn.GetAccount(data.PublicID)
 		if err != nil {
 			return err
 		}
@@ -119,290 +192,456 @@ func (c *Consumer) processAccountEvts(msg *kafka.Message) error {
 			}
 		}()
 
-		acc.Role.UnmarshalText(data.Data.Role)
-		return c.DBConn.SaveAccount(acc)
+		acc.Role.UnmarshalText(data.Role)
+		return conn.SaveAccount(acc)
 	default:
-		fmt.Println("ignoring unknown event", payload.EventName)
+		fmt.Println("ignoring unknown event", evt)
 	}
 
 	return nil
 }
 
-func (c *Consumer) processAccountsCUDs(msg *kafka.Message) error {
-	var payload Event
-	if err := json.Unmarshal(msg.Value, &payload); err != nil {
-		return err
-	}
-
-	switch payload.EventName {
-	case AccountUpdatedEvt:
-		var data AccountUpdatePayload
-		if err := json.Unmarshal([]byte(msg.Value), &data); err != nil {
+func (c *Consumer) processAccountsCUDs(conn db.Connection, evt *events.Event) error {
+	switch evt.EventName {
+	case events.AccountUpdatedEvt:
+		var data events.AccountUpdatedV1
+		if err := json.Unmarshal(evt.Data, &data); err != nil {
 			return err
 		}
 
-		acc, err := c.DBConn.GetAccount(data.Data.PublicID)
+		acc, err := conn.GetAccount(data.PublicID)
 		if err != nil {
 			return err
 		}
 
 		if acc.ID == 0 {
-			uid, err := uuid.Parse(data.Data.PublicID)
+			uid, err := uuid.Parse(data.PublicID)
 			if err != nil {
 				return err
 			}
 
-			return c.DBConn.CreateAccount(&db.BillingAccount{
+			return conn.CreateAccount(&db.BillingAccount{
 				PublicID: uid,
-				Email:    data.Data.Email,
+				Email:    data.Email,
 			})
 		}
 
-		acc.Email = data.Data.Email
-		return c.DBConn.SaveAccount(acc)
-	case AccountCreatedEvt:
-		var data AccountUpdatePayload
-		if err := json.Unmarshal([]byte(msg.Value), &data); err != nil {
+		acc.Email = data.Email
+		return conn.SaveAccount(acc)
+	case events.AccountCreatedEvt:
+		var data events.AccountCreatedV2
+		if err := json.Unmarshal(evt.Data, &data); err != nil {
 			return err
 		}
 
-		uid, err := uuid.Parse(data.Data.PublicID)
+		uid, err := uuid.Parse(data.PublicID)
 		if err != nil {
 			return err
 		}
 
-		return c.DBConn.CreateAccount(&db.BillingAccount{
+		return conn.CreateAccount(&db.BillingAccount{
 			PublicID: uid,
-			Email:    data.Data.Email,
+			Email:    data.Email,
 		})
+	case events.AccountDeletedEvt:
+		var data events.AccountDeletedV1
+		if err := json.Unmarshal(evt.Data, &data); err != nil {
+			return err
+		}
+
+		acc, err := conn.GetAccount(data.PublicID)
+		if err != nil {
+			return err
+		}
+		if acc.Frozen() {
+			return nil
+		}
+		// keep the deleted account around, so a late AccountCreated doesn't
+		// bring it back
+		if acc.ID == 0 {
+			if acc.PublicID, err = uuid.Parse(data.PublicID); err != nil {
+				return err
+			}
+			if err := conn.CreateAccount(acc); err != nil {
+				return err
+			}
+		}
+
+		return c.settleAccount(conn, evt, acc)
 	default:
-		fmt.Println("ignoring unknown event", payload.EventName)
+		fmt.Println("ignoring unknown event", evt)
 	}
 
 	return nil
 }
 
-func (c *Consumer) processTaskCUDs(msg *kafka.Message) error {
-	var payload Event
-	if err := json.Unmarshal(msg.Value, &payload); err != nil {
+// settleAccount freezes the deleted account and publishes the transaction
+// its balance was settled with, if any.
+func (c *Consumer) settleAccount(conn db.Connection, evt *events.Event, acc *db.BillingAccount) error {
+	tx, err := conn.FreezeAccount(acc)
+	if err != nil || tx == nil {
+		return err
+	}
+	log.Println("account", acc.PublicID, "frozen, settled with", tx.Type, tx.Cost)
+
+	meta := events.CausedBy(evt)
+	if err := c.Producer.TxCreatedMsg(conn, meta, *tx); err != nil {
 		return err
 	}
+	if tx.Type == db.TxType_MakePayment {
+		return c.Producer.PaymentDoneMsg(conn, meta, *tx)
+	}
+	return c.Producer.TxAppliedMsg(conn, meta, *tx)
+}
 
-	switch payload.EventName {
-	case TaskUpdatedEvt:
-		var data TaskUpdatePayload
-		if err := json.Unmarshal([]byte(msg.Value), &data); err != nil {
+// Task CUDs and task business events come from different topics, so their
+// order is not guaranteed: TaskAssigned may come before TaskCreated. The
+// first event about a task creates and prices it, the CUDs only fill in the
+// details and never reprice it.
+func (c *Consumer) processTaskCUDs(conn db.Connection, evt *events.Event) error {
+	switch evt.EventName {
+	case events.TaskUpdatedEvt:
+		var data events.TaskUpdatedV2
+		if err := json.Unmarshal(evt.Data, &data); err != nil {
 			return err
 		}
 
-		task, err := c.DBConn.GetBillingTask(data.Data.PublicID)
+		task, err := c.lockTask(conn, evt, data.PublicID)
 		if err != nil {
 			return err
 		}
 
-		task.Description = data.Data.Description
-		return c.DBConn.SaveBillingTask(task)
-	case TaskCreatedEvt:
-		var data TaskUpdatePayload
-		if err := json.Unmarshal([]byte(msg.Value), &data); err != nil {
+		task.Title = data.Title
+		task.JiraID = data.JiraID
+		task.Description = data.Description
+		return conn.SaveBillingTask(task)
+	case events.TaskCreatedEvt:
+		var data events.TaskCreatedV2
+		if err := json.Unmarshal(evt.Data, &data); err != nil {
 			return err
 		}
 
-		uid, err := uuid.Parse(data.Data.PublicID)
+		task, err := c.lockTask(conn, evt, data.PublicID)
 		if err != nil {
 			return err
 		}
 
-		t := &db.BillingTask{
-			PublicID:    uid,
-			OwnerID:     data.Data.OwnerID,
-			Status:      db.Status(data.Data.Status),
-			Description: data.Data.Description,
+		// owner and status may already be set by the business events
+		if task.OwnerID == uuid.Nil {
+			ownerID, err := uuid.Parse(data.OwnerID)
+			if err != nil {
+				return err
+			}
+			task.OwnerID = ownerID
+		}
+		if task.Status == db.Status_NotDefined {
+			task.Status = db.Status(data.Status)
+		}
+		task.Title = data.Title
+		task.JiraID = data.JiraID
+		task.Description = data.Description
+		return conn.SaveBillingTask(task)
+	case events.TaskDeletedEvt:
+		var data events.TaskDeletedV2
+		if err := json.Unmarshal(evt.Data, &data); err != nil {
+			return err
+		}
+
+		task, err := c.lockTask(conn, evt, data.PublicID)
+		if err != nil {
+			return err
+		}
+		if task.Deleted {
+			return nil
+		}
+		fillTask(task, data.Title, data.JiraID, data.Description)
+
+		task.Deleted = true
+		if err := conn.SaveBillingTask(task); err != nil {
+			return err
+		}
+
+		// the fee is what the worker pays for the reward, a completed task
+		// keeps both
+		if task.Paid || task.Status == db.Status_Done || task.OwnerID == uuid.Nil {
+			return nil
+		}
+		return c.reverseAssignFee(conn, evt, task)
+	case events.TaskRestoredEvt:
+		var data events.TaskRestoredV1
+		if err := json.Unmarshal(evt.Data, &data); err != nil {
+			return err
+		}
+
+		task, err := c.lockTask(conn, evt, data.PublicID)
+		if err != nil {
+			return err
+		}
+		if !task.Deleted {
+			return nil
 		}
 
-		t.SetCosts()
+		task.Deleted = false
+		if err := conn.SaveBillingTask(task); err != nil {
+			return err
+		}
 
-		return c.DBConn.CreateBillingTask(t)
+		if task.Paid || task.Status == db.Status_Done || task.OwnerID == uuid.Nil {
+			return nil
+		}
+		return c.rechargeAssignFee(conn, evt, task)
 	default:
-		fmt.Println("ignoring unknown event", payload.EventName)
+		fmt.Println("ignoring unknown event", evt)
 	}
 
 	return nil
 }
 
-func (c *Consumer) processTaskEvts(msg *kafka.Message) error {
-	var payload Event
-	if err := json.Unmarshal(msg.Value, &payload); err != nil {
-		return err
-	}
+func (c *Consumer) processTaskEvts(conn db.Connection, evt *events.Event) error {
+	switch evt.EventName {
+	case events.TaskAssignedEvt:
+		var data events.TaskAssignedV2
+		if err := json.Unmarshal(evt.Data, &data); err != nil {
+			return err
+		}
 
-	switch payload.EventName {
-	case TaskAssignedEvt:
-		var data TaskAssignedPayload
-		if err := json.Unmarshal([]byte(msg.Value), &data); err != nil {
+		task, err := c.lockTask(conn, evt, data.PublicID)
+		if err != nil {
 			return err
 		}
+		fillTask(task, data.Title, data.JiraID, data.Description)
 
-		task, err := c.DBConn.GetBillingTask(data.Data.PublicID)
+		ownerID, err := uuid.Parse(data.OwnerID)
 		if err != nil {
 			return err
 		}
 
-		task.OwnerID = data.Data.OwnerID
-		if err := c.DBConn.SaveBillingTask(task); err != nil {
+		task.OwnerID = ownerID
+		if err := conn.SaveBillingTask(task); err != nil {
 			return err
 		}
+		if task.Deleted {
+			fmt.Println("task", task.PublicID, "is deleted, skipping", evt)
+			return nil
+		}
 
 		tx := &db.Transaction{
 			PublicID:    uuid.New(),
 			OwnerID:     task.OwnerID,
+			TaskID:      task.PublicID,
 			Cost:        task.AssignCost,
 			Type:        db.TxType_Withdraw,
-			Description: task.Description,
+			Description: task.Name(),
 		}
-		if err := c.DBConn.CreateTransaction(tx); err != nil {
+		return c.applyTX(conn, evt, tx)
+	case events.TaskCompletedEvt:
+		var data events.TaskCompletedV2
+		if err := json.Unmarshal(evt.Data, &data); err != nil {
 			return err
 		}
 
-		if err := c.Producer.TxCreatedMsg(*tx); err != nil {
-			log.Println("failext to publish!", err)
+		task, err := c.lockTask(conn, evt, data.PublicID)
+		if err != nil {
+			return err
+		}
+		// tasks done before Paid was tracked are paid as well
+		if task.Paid || task.Status == db.Status_Done {
+			fmt.Println("reward for task", task.PublicID, "is already paid, skipping", evt)
+			return nil
 		}
+		if task.Deleted {
+			fmt.Println("task", task.PublicID, "is deleted, skipping", evt)
+			return nil
+		}
+		fillTask(task, data.Title, data.JiraID, data.Description)
 
-		return c.processTX(tx)
-	case TaskCompletedEvt:
-		var data TaskCompletedPayload
-		if err := json.Unmarshal([]byte(msg.Value), &data); err != nil {
-			return err
+		if task.OwnerID == uuid.Nil {
+			ownerID, err := uuid.Parse(data.OwnerID)
+			if err != nil {
+				return err
+			}
+			task.OwnerID = ownerID
 		}
 
-		task, err := c.DBConn.GetBillingTask(data.Data.PublicID)
+		at, err := eventTime(evt)
 		if err != nil {
 			return err
 		}
 
 		task.Status = db.Status_Done
-		task.CloseDay = time.Now().Truncate(time.Hour * 24)
-		if err := c.DBConn.SaveBillingTask(task); err != nil {
+		task.CloseDay = at.UTC().Truncate(db.BillingCycleDuration)
+		task.Paid = true
+		if err := conn.SaveBillingTask(task); err != nil {
 			return err
 		}
 
 		tx := &db.Transaction{
 			PublicID:    uuid.New(),
 			OwnerID:     task.OwnerID,
+			TaskID:      task.PublicID,
 			Cost:        task.DoneCost,
 			Type:        db.TxType_Add,
-			Description: task.Description,
+			Description: task.Name(),
 		}
-		if err := c.DBConn.CreateTransaction(tx); err != nil {
-			return err
-		}
-		if err := c.Producer.TxCreatedMsg(*tx); err != nil {
-			log.Println("failext to publish!", err)
-		}
-		return c.processTX(tx)
+		return c.applyTX(conn, evt, tx)
 	default:
-		fmt.Println("ignoring unknown event", payload.EventName)
+		fmt.Println("ignoring unknown event", evt)
 	}
 
 	return nil
 }
 
-type Event struct {
-	EventID      string `json:"event_id"`
-	EventVersion int64  `json:"event_version"`
-	EventTime    string `json:"event_time"`
-	Producer     string `json:"producer"`
-	EventName    string `json:"event_name"`
+// reverseAssignFee gives the assignee of the deleted task back the fee they
+// were last charged for it in the current cycle.
+func (c *Consumer) reverseAssignFee(conn db.Connection, evt *events.Event, task *db.BillingTask) error {
+	bc, err := conn.GetCurrentBillingCycle()
+	if err != nil {
+		return err
+	}
+	txs, err := conn.GetTaskTransactions(task.PublicID, bc.PublicID)
+	if err != nil {
+		return err
+	}
+
+	fee := lastFeeMove(txs, task.OwnerID)
+	if fee == nil || fee.Type != db.TxType_Withdraw {
+		log.Println("no fee to reverse for task", task.PublicID, "owner", task.OwnerID, "in cycle", bc.PublicID, evt)
+		return nil
+	}
+
+	tx := &db.Transaction{
+		PublicID:    uuid.New(),
+		OwnerID:     task.OwnerID,
+		TaskID:      task.PublicID,
+		Cost:        fee.Cost,
+		Type:        db.TxType_Add,
+		Description: fmt.Sprintf("reversal: %s", task.Name()),
+	}
+	return c.applyTX(conn, evt, tx)
 }
 
-type AccountUpdatePayload struct {
-	Data struct {
-		PublicID string `json:"public_id"`
-		Email    string `json:"email"`
-		FullName string `json:"full_name"`
-		Position string `json:"position"`
-	} `json:"data"`
+// lastFeeMove returns the owner's last fee charge or reversal for an unpaid
+// task, txs oldest first. The task is not paid, so money added for it can
+// only be a reversal.
+func lastFeeMove(txs []db.Transaction, ownerID uuid.UUID) *db.Transaction {
+	var last *db.Transaction
+	for i, tx := range txs {
+		if tx.OwnerID != ownerID || tx.Status != db.TxStatus_Success {
+			continue
+		}
+		if tx.Type == db.TxType_Withdraw || tx.Type == db.TxType_Add {
+			last = &txs[i]
+		}
+	}
+	return last
 }
 
-type RoleChangePayload struct {
-	Data struct {
-		PublicID string `json:"public_id"`
-		Role     string `json:"role"`
-	} `json:"data"`
+// rechargeAssignFee charges the assignee of the restored task the fee that
+// was reversed on its deletion.
+func (c *Consumer) rechargeAssignFee(conn db.Connection, evt *events.Event, task *db.BillingTask) error {
+	txs, err := conn.GetTaskTransactions(task.PublicID)
+	if err != nil {
+		return err
+	}
+
+	reversal := lastFeeMove(txs, task.OwnerID)
+	if reversal == nil || reversal.Type != db.TxType_Add {
+		return nil
+	}
+	reversalBC, err := conn.GetBillingCycle(reversal.BillingCycleID.String())
+	if err != nil {
+		return err
+	}
+	return c.applyTX(conn, evt, rechargeTx(task, reversal, reversalBC))
 }
 
-type TaskUpdatePayload struct {
-	Data struct {
-		PublicID    string    `json:"public_id"`
-		OwnerID     uuid.UUID `json:"owner_id"`
-		Description string    `json:"description"`
-		Status      int       `json:"status"`
-	} `json:"data"`
+// rechargeTx charges the reversed fee again. A reversal in a cycle that is
+// still open is undone by a plain charge, one in a closed cycle by a
+// correction of its day.
+func rechargeTx(task *db.BillingTask, reversal *db.Transaction, reversalBC *db.BillingCycle) *db.Transaction {
+	tx := &db.Transaction{
+		PublicID:    uuid.New(),
+		OwnerID:     task.OwnerID,
+		TaskID:      task.PublicID,
+		Cost:        reversal.Cost,
+		Type:        db.TxType_Withdraw,
+		Description: task.Name(),
+	}
+	if reversalBC.Status == db.BillingCycleStatus_Closed {
+		correct(tx, reversalBC.StartedAt)
+	}
+	return tx
+}
+
+// lockTask locks the task the event is about, creating and pricing it if
+// this is the first event about it. The new prices are published for the
+// other services.
+func (c *Consumer) lockTask(conn db.Connection, evt *events.Event, id string) (*db.BillingTask, error) {
+	task, priced, err := conn.GetOrCreateBillingTaskForUpdate(id)
+	if err != nil || !priced {
+		return task, err
+	}
+	return task, c.Producer.TaskPricedMsg(conn, events.CausedBy(evt), *task)
 }
 
-type TaskAssignedPayload struct {
-	Data struct {
-		PublicID string    `json:"public_id"`
-		OwnerID  uuid.UUID `json:"owner_id"`
-	} `json:"data"`
+// fillTask names a placeholder task from a business event, so its
+// transactions are readable before TaskCreated comes.
+func fillTask(task *db.BillingTask, title, jiraID, descr string) {
+	if task.Title != "" {
+		return
+	}
+	task.Title = title
+	task.JiraID = jiraID
+	task.Description = descr
 }
 
-type TaskCompletedPayload struct {
-	Data struct {
-		PublicID    string    `json:"public_id"`
-		OwnerID     uuid.UUID `json:"owner_id"`
-		Description string    `json:"description"`
-	} `json:"data"`
+// correct marks tx as a correction of the given day, billed in a later cycle.
+func correct(tx *db.Transaction, day time.Time) {
+	tx.CorrectsDay = &day
+	tx.Description = fmt.Sprintf("correction for %s: %s", day.Format("2006-01-02"), tx.Description)
 }
 
-func (c *Consumer) processTX(tx *db.Transaction) error {
-	bcs, err := c.DBConn.GetAllBillingCycles()
+// applyTX records and posts tx, the events about it are caused by evt. The
+// money goes to the billing cycle of the day evt happened or, if that cycle
+// is already closed, to the current one as a correction of that day. A tx
+// already marked as a correction keeps its day.
+// Nothing is billed to frozen accounts, their balance is already settled.
+func (c *Consumer) applyTX(conn db.Connection, evt *events.Event, tx *db.Transaction) error {
+	acc, err := conn.GetAccount(tx.OwnerID.String())
 	if err != nil {
 		return err
 	}
-	bc := bcs[0]
-
-	switch tx.Type {
-	case db.TxType_Add, db.TxType_Withdraw:
-		owner, err := c.DBConn.GetAccount(tx.OwnerID.String())
-		if err != nil {
-			return err
-		}
-
-		if tx.Type == db.TxType_Withdraw {
-			owner.Balance -= tx.Cost
-			bc.DaySum -= tx.Cost
-		} else {
-			owner.Balance += tx.Cost
-			bc.DaySum += tx.Cost
-		}
-
-		owner.TransactionLog = append(owner.TransactionLog, tx.PublicID.String())
-		if err := c.DBConn.SaveAccount(owner); err != nil {
-			return err
-		}
-	case db.TxType_MakePayment:
-		users, err := c.DBConn.GetAllAccounts()
-		if err != nil {
-			return err
-		}
-
-		for _, user := range users {
-			user.TransactionLog = append(user.TransactionLog, tx.PublicID.String())
-			if err := c.DBConn.SaveAccount(&user); err != nil {
-				return err
-			}
-		}
+	if acc.Frozen() {
+		log.Println("account", tx.OwnerID, "is frozen, skipping tx for", evt)
+		return nil
 	}
 
-	bc.TransactionLog = append(bc.TransactionLog, tx.PublicID.String())
-	if err := c.DBConn.SaveBillingCycle(&bc); err != nil {
+	at, err := eventTime(evt)
+	if err != nil {
+		return err
+	}
+	bc, late, err := conn.GetBillingCycleForEvent(at)
+	if err != nil {
 		return err
 	}
 
-	if err := c.Producer.TxAppliedMsg(*tx); err != nil {
-		log.Println("failext to publish!", err)
+	tx.BillingCycleID = bc.PublicID
+	tx.EventTime = at
+	if late && tx.CorrectsDay == nil {
+		day := at.UTC().Truncate(db.BillingCycleDuration)
+		correct(tx, day)
+		log.Println("late event, correcting", day.Format("2006-01-02"), "in cycle", bc.PublicID, evt)
 	}
 
-	return nil
+	meta := events.CausedBy(evt)
+	if err := conn.CreateTransaction(tx); err != nil {
+		return err
+	}
+	if err := c.Producer.TxCreatedMsg(conn, meta, *tx); err != nil {
+		return err
+	}
+	if err := conn.PostTransaction(tx); err != nil {
+		return err
+	}
+	return c.Producer.TxAppliedMsg(conn, meta, *tx)
 }
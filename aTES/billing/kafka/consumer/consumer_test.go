@@ -0,0 +1,97 @@
+package consumer
+
+import (
+	"billing/db"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestLastFeeMove(t *testing.T) {
+	owner, other := uuid.New(), uuid.New()
+	fee := db.Transaction{OwnerID: owner, Type: db.TxType_Withdraw, Status: db.TxStatus_Success, Cost: 15}
+	reversal := db.Transaction{OwnerID: owner, Type: db.TxType_Add, Status: db.TxStatus_Success, Cost: 15}
+
+	tests := []struct {
+		name string
+		txs  []db.Transaction
+		want *db.TxType
+	}{
+		{name: "no transactions"},
+		{name: "fee charged", txs: []db.Transaction{fee}, want: txType(db.TxType_Withdraw)},
+		{name: "fee reversed", txs: []db.Transaction{fee, reversal}, want: txType(db.TxType_Add)},
+		{name: "fee charged again", txs: []db.Transaction{fee, reversal, fee}, want: txType(db.TxType_Withdraw)},
+		{
+			name: "fee of the previous assignee",
+			txs:  []db.Transaction{{OwnerID: other, Type: db.TxType_Withdraw, Status: db.TxStatus_Success}},
+		},
+		{
+			name: "failed reversal",
+			txs:  []db.Transaction{fee, {OwnerID: owner, Type: db.TxType_Add, Status: db.TxStatus_Failed}},
+			want: txType(db.TxType_Withdraw),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := lastFeeMove(tt.txs, owner)
+			switch {
+			case tt.want == nil && got != nil:
+				t.Errorf("got %s, want none", got.Type)
+			case tt.want != nil && got == nil:
+				t.Errorf("got none, want %s", *tt.want)
+			case tt.want != nil && got.Type != *tt.want:
+				t.Errorf("got %s, want %s", got.Type, *tt.want)
+			}
+		})
+	}
+}
+
+func txType(t db.TxType) *db.TxType {
+	return &t
+}
+
+func TestRechargeTx(t *testing.T) {
+	task := &db.BillingTask{PublicID: uuid.New(), OwnerID: uuid.New(), Title: "fix login"}
+	reversal := &db.Transaction{Type: db.TxType_Add, Cost: 15}
+	day := time.Date(2022, 5, 16, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name           string
+		status         db.BillingCycleStatus
+		wantCorrection bool
+	}{
+		{name: "reversal in an open cycle", status: db.BillingCycleStatus_Open},
+		{name: "reversal in a closed cycle", status: db.BillingCycleStatus_Closed, wantCorrection: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := rechargeTx(task, reversal, &db.BillingCycle{StartedAt: day, Status: tt.status})
+			if tx.Type != db.TxType_Withdraw || tx.Cost != 15 || tx.OwnerID != task.OwnerID || tx.TaskID != task.PublicID {
+				t.Errorf("got %s of %d to %s for %s, want the fee charged to the assignee", tx.Type, tx.Cost, tx.OwnerID, tx.TaskID)
+			}
+			if (tx.CorrectsDay != nil) != tt.wantCorrection {
+				t.Fatalf("corrects day %v, want correction %v", tx.CorrectsDay, tt.wantCorrection)
+			}
+			if tt.wantCorrection && !tx.CorrectsDay.Equal(day) {
+				t.Errorf("corrects %s, want %s", tx.CorrectsDay, day)
+			}
+		})
+	}
+}
+
+func TestFillTask(t *testing.T) {
+	placeholder := &db.BillingTask{PublicID: uuid.New()}
+	fillTask(placeholder, "fix login", "POPUG-42", "the button does nothing")
+	if placeholder.Name() != "[POPUG-42] fix login" || placeholder.Description != "the button does nothing" {
+		t.Errorf("placeholder named %q, description %q", placeholder.Name(), placeholder.Description)
+	}
+
+	// a task TaskCreated or TaskUpdated named keeps its name
+	fillTask(placeholder, "stale title", "", "")
+	if placeholder.Name() != "[POPUG-42] fix login" {
+		t.Errorf("named task renamed to %q", placeholder.Name())
+	}
+}
@@ -0,0 +1,44 @@
+package consumer
+
+import (
+	"events"
+	"messaging/versioning"
+	"regexp"
+	"strings"
+)
+
+func init() {
+	for _, evtName := range []string{events.TaskCreatedEvt, events.TaskUpdatedEvt, events.TaskDeletedEvt, events.TaskAssignedEvt, events.TaskCompletedEvt} {
+		versioning.Register(evtName, 1, taskV1ToV2)
+	}
+	versioning.Register(events.AccountCreatedEvt, 1, accountCreatedV1ToV2)
+}
+
+var jiraIDPrefix = regexp.MustCompile(`^\s*\[([^\]]*)\]\s*`)
+
+// taskV1ToV2 splits the "[JIRA-42] Title" titles of v1 into jira_id and title.
+func taskV1ToV2(data map[string]interface{}) error {
+	title, _ := data["title"].(string)
+	jiraID := ""
+	if m := jiraIDPrefix.FindStringSubmatch(title); m != nil {
+		jiraID = strings.TrimSpace(m[1])
+		title = title[len(m[0]):]
+	}
+	data["title"] = title
+	data["jira_id"] = jiraID
+	return nil
+}
+
+// accountCreatedV1ToV2 splits full_name of v1 into first_name and last_name.
+func accountCreatedV1ToV2(data map[string]interface{}) error {
+	fullName, _ := data["full_name"].(string)
+	delete(data, "full_name")
+
+	first, last := fullName, ""
+	if i := strings.IndexByte(fullName, ' '); i >= 0 {
+		first, last = fullName[:i], strings.TrimSpace(fullName[i+1:])
+	}
+	data["first_name"] = first
+	data["last_name"] = last
+	return nil
+}
@@ -0,0 +1,67 @@
+package consumer
+
+import (
+	"encoding/json"
+	"events"
+	"messaging/versioning"
+	"testing"
+)
+
+func TestUpcastTaskV1(t *testing.T) {
+	tests := []struct {
+		title      string
+		wantTitle  string
+		wantJiraID string
+	}{
+		{title: "[POPUG-42] Fix login", wantTitle: "Fix login", wantJiraID: "POPUG-42"},
+		{title: "  [ POPUG-7 ]  Spaces", wantTitle: "Spaces", wantJiraID: "POPUG-7"},
+		{title: "No jira id", wantTitle: "No jira id"},
+		{title: "Brackets [later] stay", wantTitle: "Brackets [later] stay"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.title, func(t *testing.T) {
+			data, _ := json.Marshal(map[string]interface{}{"public_id": "task-1", "title": tt.title})
+			evt := &events.Event{EventName: events.TaskCreatedEvt, EventVersion: 1, Data: data}
+			if err := versioning.Upcast(evt); err != nil {
+				t.Fatal(err)
+			}
+
+			var got events.TaskCreatedV2
+			if err := json.Unmarshal(evt.Data, &got); err != nil {
+				t.Fatal(err)
+			}
+			if evt.EventVersion != 2 || got.Title != tt.wantTitle || got.JiraID != tt.wantJiraID {
+				t.Errorf("got v%d title %q jira_id %q, want v2 %q %q", evt.EventVersion, got.Title, got.JiraID, tt.wantTitle, tt.wantJiraID)
+			}
+		})
+	}
+}
+
+func TestUpcastAccountCreatedV1(t *testing.T) {
+	tests := []struct {
+		fullName  string
+		wantFirst string
+		wantLast  string
+	}{
+		{fullName: "Ivan Popugaev", wantFirst: "Ivan", wantLast: "Popugaev"},
+		{fullName: "Ivan Ivanovich Popugaev", wantFirst: "Ivan", wantLast: "Ivanovich Popugaev"},
+		{fullName: "Kesha", wantFirst: "Kesha"},
+		{fullName: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fullName, func(t *testing.T) {
+			data := map[string]interface{}{"full_name": tt.fullName}
+			if err := accountCreatedV1ToV2(data); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := data["full_name"]; ok {
+				t.Error("full_name is kept")
+			}
+			if data["first_name"] != tt.wantFirst || data["last_name"] != tt.wantLast {
+				t.Errorf("got %q %q, want %q %q", data["first_name"], data["last_name"], tt.wantFirst, tt.wantLast)
+			}
+		})
+	}
+}
@@ -3,44 +3,44 @@ package producer
 import (
 	"billing/db"
 	"encoding/json"
+	"events"
 	"fmt"
+	"messaging/outbox"
+	"messaging/versioning"
 	"os"
-	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/dkolistratova/eventschemaregistry"
 	"github.com/google/uuid"
 )
 
-const (
-	TxEventsTopic = "transactions"
-	TxCUDsTopic   = "transactions-stream"
+// Topics are the topics this producer writes to.
+var Topics = []string{events.TransactionEventsTopic, events.TransactionCUDsTopic, events.TaskPriceEventsTopic}
 
-	TxCreatedEvt = "TransactionCreated"
-	TxUpdatedEvt = "TransactionUpdated"
-
-	TxAppliedEvt     = "TransactionApplied"
-	TxPaymentDoneEvt = "TransactionPaymentDone"
-)
-
-var validatorByEvtName = map[string]string{
-	TxCreatedEvt:     "transactions.created",
-	TxUpdatedEvt:     "transactions.updated",
-	TxAppliedEvt:     "transactions.applied",
-	TxPaymentDoneEvt: "transactions.paymentdone",
+// DefaultVersions are the schema versions each event is published in.
+var DefaultVersions = versioning.Versions{
+	events.TransactionCreatedEvt:     {1},
+	events.TransactionUpdatedEvt:     {1},
+	events.TransactionAppliedEvt:     {1},
+	events.TransactionPaymentDoneEvt: {1},
+	events.TaskPricedEvt:             {1},
 }
 
 type Producer struct {
 	*kafka.Producer
-	deliveryCh chan kafka.Event
-	validator  *eventschemaregistry.Validator
+	dbConn    db.Connection
+	validator *eventschemaregistry.Validator
+
+	// Versions list both versions of an event while its schema is migrated.
+	Versions versioning.Versions
 }
 
-func NewProducer() *Producer {
+func NewProducer(dbConn db.Connection) *Producer {
 	conf := kafka.ConfigMap{
-		"bootstrap.servers": "broker:29092",
-		"client.id":         "localhost:3002",
-		"acks":              "all",
+		"bootstrap.servers":  "broker:29092",
+		"client.id":          "localhost:3002",
+		"acks":               "all",
+		"enable.idempotence": true,
 	}
 
 	pr, err := kafka.NewProducer(&conf)
@@ -50,76 +50,157 @@ func NewProducer() *Producer {
 	}
 
 	return &Producer{
-		Producer:   pr,
-		deliveryCh: make(chan kafka.Event, 10000),
-		validator:  eventschemaregistry.NewValidator("/app/event_schema_registry/schemas"),
+		Producer:  pr,
+		dbConn:    dbConn,
+		validator: eventschemaregistry.NewValidator("/app/event_schema_registry/schemas"),
+		Versions:  DefaultVersions,
 	}
 }
 
+// Run relays the outbox to kafka until the process exits.
 func (p *Producer) Run() {
-	defer p.Producer.Close()
-
-	go func() {
-		for {
-			for e := range p.Events() {
-				switch ev := e.(type) {
-				case *kafka.Message:
-					if ev.TopicPartition.Error != nil {
-						fmt.Printf("Failed to deliver message: %v\n", ev.TopicPartition)
-					} else {
-						fmt.Printf("Successfully produced record to topic %s partition [%d] @ offset %v\n",
-							*ev.TopicPartition.Topic, ev.TopicPartition.Partition, ev.TopicPartition.Offset)
-					}
-				}
+	outbox.NewRelay(p.Producer, p.dbConn.DB).Run()
+}
+
+func txV1(t db.Transaction) events.TransactionCreatedV1 {
+	status := int(t.Status)
+	return events.TransactionCreatedV1{
+		PublicID:    t.PublicID.String(),
+		OwnerID:     t.OwnerID.String(),
+		Cost:        t.Cost,
+		Type:        int(t.Type),
+		Description: t.Description,
+		Status:      &status,
+	}
+}
+
+// txDataByVersion shapes a transaction for each event and schema version.
+var txDataByVersion = map[string]map[int]func(t db.Transaction) interface{}{
+	events.TransactionCreatedEvt: {
+		1: func(t db.Transaction) interface{} { return txV1(t) },
+	},
+	events.TransactionUpdatedEvt: {
+		1: func(t db.Transaction) interface{} {
+			return events.TransactionUpdatedV1{
+				PublicID:    t.PublicID.String(),
+				OwnerID:     t.OwnerID.String(),
+				Cost:        t.Cost,
+				Type:        int(t.Type),
+				Status:      int(t.Status),
+				Description: t.Description,
 			}
-		}
-	}()
+		},
+	},
+	events.TransactionAppliedEvt: {
+		1: func(t db.Transaction) interface{} {
+			return events.TransactionAppliedV1{
+				PublicID:    t.PublicID.String(),
+				OwnerID:     t.OwnerID.String(),
+				Cost:        t.Cost,
+				Type:        int(t.Type),
+				Description: t.Description,
+			}
+		},
+	},
+	events.TransactionPaymentDoneEvt: {
+		1: func(t db.Transaction) interface{} {
+			return events.TransactionPaymentDoneV1{
+				PublicID: t.PublicID.String(),
+				OwnerID:  t.OwnerID.String(),
+				Cost:     t.Cost,
+			}
+		},
+	},
 }
 
-func (p *Producer) produceEvt(topic, evtName string, evt interface{}) error {
-	msg, err := json.Marshal(Event{
-		EventID:      uuid.NewString(),
-		EventVersion: 1,
-		EventTime:    time.Now().String(),
-		EventName:    evtName,
-		Data:         evt,
-	})
-	if err != nil {
-		fmt.Println("produceTxEvt err", err)
-		return err
+// taskDataByVersion shapes a priced task for each event and schema version.
+var taskDataByVersion = map[string]map[int]func(t db.BillingTask) interface{}{
+	events.TaskPricedEvt: {
+		1: func(t db.BillingTask) interface{} {
+			return events.TaskPricedV1{
+				PublicID:      t.PublicID.String(),
+				AssignCost:    t.AssignCost,
+				DoneCost:      t.DoneCost,
+				PolicyVersion: t.PricingPolicyVersion,
+			}
+		},
+	},
+}
+
+// produceEvt validates the event and puts it into the outbox using conn,
+// so it is published only if the surrounding transaction commits.
+// Events with the same key are delivered in order. A copy is published for
+// every configured version, all of them share the event id, data shapes the
+// payload of each. meta links the event to the user action or the event it
+// was produced for.
+func (p *Producer) produceEvt(conn db.Connection, meta events.Meta, topic, key, evtName string, data func(version int) (interface{}, bool)) error {
+	versions := p.Versions[evtName]
+	if len(versions) == 0 {
+		return fmt.Errorf("no versions configured for %s", evtName)
 	}
-	if err := p.validator.Validate(msg, validatorByEvtName[evtName], 1); err != nil {
-		fmt.Println("produceTxEvt validation failed", err)
-		return err
+
+	eventID := uuid.NewString()
+	for _, version := range versions {
+		payload, ok := data(version)
+		if !ok {
+			return fmt.Errorf("unsupported %s version %d", evtName, version)
+		}
+
+		evt, err := events.New(eventID, "billing", evtName, version, meta, payload)
+		if err != nil {
+			fmt.Println("produceTxEvt err", err)
+			return err
+		}
+		msg, err := json.Marshal(evt)
+		if err != nil {
+			fmt.Println("produceTxEvt err", err)
+			return err
+		}
+		if err := p.validator.Validate(msg, events.SchemaByEvt[evtName], version); err != nil {
+			fmt.Println("produceTxEvt validation failed", evtName, version, err)
+			return err
+		}
+		if err := conn.AddOutboxMessage(topic, key, p.Versions.Headers(evtName, version), msg); err != nil {
+			return err
+		}
 	}
-	return p.Produce(&kafka.Message{
-		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
-		Value:          msg},
-		p.deliveryCh,
-	)
+	return nil
 }
 
-type Event struct {
-	EventID      string      `json:"event_id"`
-	EventVersion int64       `json:"event_version"`
-	EventTime    string      `json:"event_time"`
-	Producer     string      `json:"producer"`
-	EventName    string      `json:"event_name"`
-	Data         interface{} `json:"data"`
+func (p *Producer) produceTxEvt(conn db.Connection, meta events.Meta, topic, evtName string, t db.Transaction) error {
+	return p.produceEvt(conn, meta, topic, t.OwnerID.String(), evtName, func(version int) (interface{}, bool) {
+		data, ok := txDataByVersion[evtName][version]
+		if !ok {
+			return nil, false
+		}
+		return data(t), true
+	})
 }
 
-func (p *Producer) TxCreatedMsg(t db.Transaction) error {
-	return p.produceEvt(TxCUDsTopic, TxCreatedEvt, t)
+func (p *Producer) TxCreatedMsg(conn db.Connection, meta events.Meta, t db.Transaction) error {
+	return p.produceTxEvt(conn, meta, events.TransactionCUDsTopic, events.TransactionCreatedEvt, t)
 }
 
-func (p *Producer) TxUpdatedMsg(t db.Transaction) error {
-	return p.produceEvt(TxCUDsTopic, TxUpdatedEvt, t)
+func (p *Producer) TxUpdatedMsg(conn db.Connection, meta events.Meta, t db.Transaction) error {
+	return p.produceTxEvt(conn, meta, events.TransactionCUDsTopic, events.TransactionUpdatedEvt, t)
 }
 
-func (p *Producer) TxAppliedMsg(t db.Transaction) error {
-	return p.produceEvt(TxEventsTopic, TxAppliedEvt, t)
+func (p *Producer) TxAppliedMsg(conn db.Connection, meta events.Meta, t db.Transaction) error {
+	return p.produceTxEvt(conn, meta, events.TransactionEventsTopic, events.TransactionAppliedEvt, t)
 }
 
-func (p *Producer) PaymentDoneMsg(t db.Transaction) error {
-	return p.produceEvt(TxEventsTopic, TxPaymentDoneEvt, t)
+func (p *Producer) PaymentDoneMsg(conn db.Connection, meta events.Meta, t db.Transaction) error {
+	return p.produceTxEvt(conn, meta, events.TransactionEventsTopic, events.TransactionPaymentDoneEvt, t)
+}
+
+// TaskPricedMsg publishes the task costs keyed by the task, so the prices of
+// one task are delivered in order.
+func (p *Producer) TaskPricedMsg(conn db.Connection, meta events.Meta, t db.BillingTask) error {
+	return p.produceEvt(conn, meta, events.TaskPriceEventsTopic, t.PublicID.String(), events.TaskPricedEvt, func(version int) (interface{}, bool) {
+		data, ok := taskDataByVersion[events.TaskPricedEvt][version]
+		if !ok {
+			return nil, false
+		}
+		return data(t), true
+	})
 }
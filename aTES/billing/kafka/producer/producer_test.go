@@ -0,0 +1,35 @@
+package producer
+
+import (
+	"billing/db"
+	"events"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestTaskPricedData(t *testing.T) {
+	task := db.BillingTask{PublicID: uuid.New(), AssignCost: 12, DoneCost: 31, PricingPolicyVersion: 3, Title: "fix login"}
+
+	data, ok := taskDataByVersion[events.TaskPricedEvt][1](task).(events.TaskPricedV1)
+	if !ok {
+		t.Fatal("TaskPriced v1 payload is not TaskPricedV1")
+	}
+	want := events.TaskPricedV1{PublicID: task.PublicID.String(), AssignCost: 12, DoneCost: 31, PolicyVersion: 3}
+	if data != want {
+		t.Errorf("got %+v, want %+v", data, want)
+	}
+}
+
+func TestDataByVersion(t *testing.T) {
+	// every version published by default must have a payload
+	for evtName, versions := range DefaultVersions {
+		for _, v := range versions {
+			_, tx := txDataByVersion[evtName][v]
+			_, task := taskDataByVersion[evtName][v]
+			if !tx && !task {
+				t.Errorf("no %s v%d payload", evtName, v)
+			}
+		}
+	}
+}
@@ -7,9 +7,15 @@ import (
 	"billing/webserver"
 	"encoding/json"
 	"errors"
+	"events"
+	"fmt"
 	"io/ioutil"
 	"log"
+	"messaging/admin"
+	"messaging/router"
 	"net/http"
+	"os"
+	"strconv"
 	"sync"
 	"text/template"
 	"time"
@@ -42,36 +48,45 @@ func init() {
 	accountsToUpdate = make(map[uuid.UUID]bool)
 	globalSessions, _ = session.NewManager("memory", "gosessionid", 3600)
 	go globalSessions.GC()
-	t = template.Must(template.ParseFiles("templates/home.html"))
+	t = template.Must(template.ParseFiles("templates/home.html", "templates/dead_letters.html", "templates/pricing.html"))
 }
 
 type Server struct {
 	*webserver.Server
 	dbConn   db.Connection
 	producer *producer.Producer
-	bcId     uuid.UUID
+	consumer *consumer.Consumer
 }
 
+// allowedLateness is how long a billing cycle waits for late events after
+// its day is over. Events coming later are billed as corrections in the
+// current cycle.
+var allowedLateness = time.Hour
+
 func main() {
+	if v := os.Getenv("ALLOWED_LATENESS"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			panic(fmt.Errorf("bad ALLOWED_LATENESS: %w", err))
+		}
+		allowedLateness = d
+	}
+
 	srv := Server{
 		Server: webserver.New(port),
 		dbConn: db.Connect(),
 	}
 
-	bcs, err := srv.dbConn.GetAllBillingCycles()
-	if err != nil {
+	if _, err := srv.dbConn.GetOpenBillingCycle(); err != nil {
 		panic(err)
 	}
-	if len(bcs) > 1 {
-		panic("should not happen")
-	} else if len(bcs) == 0 {
-		srv.bcId = uuid.New()
-		if err := srv.dbConn.CreateBillingCycle(&db.BillingCycle{
-			PublicID: srv.bcId,
-			DaySum:   0,
-		}); err != nil {
-			panic(err)
-		}
+	if err := srv.dbConn.EnsurePricingPolicy(); err != nil {
+		panic(err)
+	}
+
+	topics := append(producer.Topics, consumer.Topics...)
+	if err := admin.EnsureTopics(admin.Topics(topics...)); err != nil {
+		panic(err)
 	}
 
 	srv.AddHandler("/login", login)
@@ -79,31 +94,45 @@ func main() {
 
 	srv.AddHandle("/", authHandler(http.HandlerFunc(srv.home)))
 	srv.AddHandle("/analytics", authHandler(http.HandlerFunc(srv.analytics)))
+	srv.AddHandle("/pricing", accounterHandler(http.HandlerFunc(srv.pricing)))
+	srv.AddHandle("/admin/dead-letters", adminHandler(http.HandlerFunc(srv.listDeadLetters)))
+	srv.AddHandle("/admin/dead-letters/redrive", adminHandler(http.HandlerFunc(srv.redriveDeadLetter)))
 
-	srv.producer = producer.NewProducer()
+	srv.producer = producer.NewProducer(srv.dbConn)
 	go func() {
 		srv.producer.Run()
 	}()
 
+	ch := make(chan uuid.UUID, 10)
 	go func() {
-		ch := make(chan uuid.UUID, 10)
-		go func() {
-			for {
-				uid := <-ch
-
-				mux.Lock()
-				accountsToUpdate[uid] = true
-				mux.Unlock()
-			}
-		}()
+		for {
+			uid := <-ch
 
-		consumer.NewConsumer(srv.dbConn, ch, *srv.producer).Run()
+			mux.Lock()
+			accountsToUpdate[uid] = true
+			mux.Unlock()
+		}
+	}()
+	srv.consumer = consumer.NewConsumer(srv.dbConn, ch, srv.producer, router.ConfigFromEnv())
+	go func() {
+		srv.consumer.Run()
 	}()
 
 	go func() {
 		for {
-			srv.closeBillingCycle()
-			time.Sleep(time.Hour * 24)
+			bc, err := srv.dbConn.GetOpenBillingCycle()
+			if err != nil {
+				log.Println("failed to get open billing cycle", err)
+				time.Sleep(time.Minute)
+				continue
+			}
+
+			// the cycle stays open for events that happened in it but come late
+			time.Sleep(time.Until(bc.EndedAt.Add(allowedLateness)))
+			if err := srv.closeBillingCycle(bc); err != nil {
+				log.Println("failed to close billing cycle", err)
+				time.Sleep(time.Minute)
+			}
 		}
 	}()
 	log.Println("Running server....", "port", port)
@@ -123,6 +152,44 @@ func authHandler(next http.Handler) http.Handler {
 	})
 }
 
+// adminHandler lets through signed in admins only.
+func adminHandler(next http.Handler) http.Handler {
+	return authHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := getCurrentUser(w, r)
+		if err != nil {
+			internalError(w)
+			return
+		}
+
+		if user.Role == nil || *user.Role != db.Role_Admin {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Forbidden"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// accounterHandler lets through signed in accounters and admins only.
+func accounterHandler(next http.Handler) http.Handler {
+	return authHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := getCurrentUser(w, r)
+		if err != nil {
+			internalError(w)
+			return
+		}
+
+		if user.Role == nil || (*user.Role != db.Role_Admin && *user.Role != db.Role_Accounter) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Forbidden"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}))
+}
+
 func getUserInfo(accessToken string) *db.BillingAccount {
 	req, err := http.NewRequest("GET", "http://oauth:3000/accounts/current", nil)
 	if err != nil {
@@ -178,6 +245,32 @@ func internalError(w http.ResponseWriter) {
 	w.Write([]byte("Internal Error"))
 }
 
+func (srv *Server) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	dls, err := srv.dbConn.GetFailedDeadLetters()
+	if err != nil {
+		log.Println("failed to get dead letters", err)
+		internalError(w)
+		return
+	}
+	t.ExecuteTemplate(w, "dead_letters", dls)
+}
+
+func (srv *Server) redriveDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.ParseForm()
+	if err := srv.consumer.Redrive(r.Form.Get("public_id"), []byte(r.Form.Get("payload"))); err != nil {
+		log.Println("failed to redrive dead letter", err)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte("Re-drive failed: " + err.Error()))
+		return
+	}
+	http.Redirect(w, r, "/admin/dead-letters", http.StatusSeeOther)
+}
+
 func (srv *Server) analytics(w http.ResponseWriter, r *http.Request) {
 	user, err := getCurrentUser(w, r)
 	if err != nil {
@@ -191,9 +284,23 @@ func (srv *Server) analytics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	bc, err := srv.dbConn.GetBillingCycle(srv.bcId.String())
+	day, err := requestedDay(r)
 	if err != nil {
-		log.Println("failed to get all txses", err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Bad day, expected YYYY-MM-DD"))
+		return
+	}
+
+	bcs, err := srv.dbConn.GetBillingCyclesByDay(day)
+	if err != nil {
+		log.Println("failed to get billing cycles", err)
+		internalError(w)
+		return
+	}
+
+	sum, err := srv.dbConn.GetBillingCyclesSum(cycleIDs(bcs)...)
+	if err != nil {
+		log.Println("failed to get day sum", err)
 		internalError(w)
 		return
 	}
@@ -215,11 +322,15 @@ func (srv *Server) analytics(w http.ResponseWriter, r *http.Request) {
 	e := json.NewEncoder(w)
 	e.SetIndent("", "  ")
 	e.Encode(struct {
-		DaySum    int            `json:"day_sum"`
-		TaskStats map[string]int `json:"tasks_stats"`
+		Day           string            `json:"day"`
+		DaySum        int               `json:"day_sum"`
+		BillingCycles []db.BillingCycle `json:"billing_cycles"`
+		TaskStats     map[string]int    `json:"tasks_stats"`
 	}{
-		DaySum:    bc.DaySum,
-		TaskStats: stats,
+		Day:           day.Format(dayLayout),
+		DaySum:        sum,
+		BillingCycles: bcs,
+		TaskStats:     stats,
 	})
 }
 
@@ -231,44 +342,172 @@ func (srv *Server) home(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if user.Role == nil || (*user.Role != db.Role_Admin && *user.Role != db.Role_Accounter) {
-		txs, err := srv.dbConn.GetAllUserTransactions(user.TransactionLog)
+		txs, err := srv.dbConn.GetAccountTransactions(user.PublicID)
 		if err != nil {
 			log.Println("failed to get user txses", err)
 			internalError(w)
 			return
 		}
+		balance, err := srv.dbConn.GetAccountBalance(user.PublicID)
+		if err != nil {
+			log.Println("failed to get user balance", err)
+			internalError(w)
+			return
+		}
 
 		t.ExecuteTemplate(w, "home", struct {
 			Transactions []db.Transaction
 			Balance      int
+			Day          string
 		}{
 			Transactions: txs,
-			Balance:      user.Balance,
+			Balance:      balance,
 		})
 		return
 	}
 
-	bc, err := srv.dbConn.GetBillingCycle(srv.bcId.String())
+	day, err := requestedDay(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("Bad day, expected YYYY-MM-DD"))
+		return
+	}
+
+	bcs, err := srv.dbConn.GetBillingCyclesByDay(day)
+	if err != nil {
+		log.Println("failed to get billing cycles", err)
+		internalError(w)
+		return
+	}
+	txs, err := srv.dbConn.GetBillingCycleTransactions(cycleIDs(bcs)...)
 	if err != nil {
-		log.Println("failed to get all txses", err)
+		log.Println("failed to get billing cycle txses", err)
 		internalError(w)
 		return
 	}
-	txs, err := srv.dbConn.GetAllUserTransactions(bc.TransactionLog)
+	sum, err := srv.dbConn.GetBillingCyclesSum(cycleIDs(bcs)...)
 	if err != nil {
-		log.Println("failed to get user txses", err)
+		log.Println("failed to get day sum", err)
 		internalError(w)
 		return
 	}
 	t.ExecuteTemplate(w, "home", struct {
 		Transactions []db.Transaction
 		Balance      int
+		Day          string
 	}{
 		Transactions: txs,
-		Balance:      bc.DaySum,
+		Balance:      sum,
+		Day:          day.Format(dayLayout),
 	})
 }
 
+const dayLayout = "2006-01-02"
+
+// pricingTimeLayout is the layout of datetime-local inputs, times are UTC.
+const pricingTimeLayout = "2006-01-02T15:04"
+
+// pricing shows the pricing policy history and the policy in effect at the
+// requested time, now by default. New policies are added by posting the form.
+func (srv *Server) pricing(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		srv.createPricingPolicy(w, r)
+		return
+	}
+
+	at := time.Now().UTC()
+	if v := r.URL.Query().Get("at"); v != "" {
+		parsed, err := time.Parse(pricingTimeLayout, v)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("bad at: " + err.Error()))
+			return
+		}
+		at = parsed
+	}
+
+	policies, err := srv.dbConn.GetPricingPolicies()
+	if err != nil {
+		log.Println("failed to get pricing policies", err)
+		internalError(w)
+		return
+	}
+	current, err := srv.dbConn.GetPricingPolicyAt(at)
+	if err != nil && !errors.Is(err, db.ErrNoPricingPolicy) {
+		log.Println("failed to get pricing policy", err)
+		internalError(w)
+		return
+	}
+
+	t.ExecuteTemplate(w, "pricing", struct {
+		At       string
+		Current  *db.PricingPolicy
+		Policies []db.PricingPolicy
+	}{
+		At:       at.Format(pricingTimeLayout),
+		Current:  current,
+		Policies: policies,
+	})
+}
+
+func (srv *Server) createPricingPolicy(w http.ResponseWriter, r *http.Request) {
+	user, err := getCurrentUser(w, r)
+	if err != nil {
+		internalError(w)
+		return
+	}
+
+	r.ParseForm()
+	var costs [4]int
+	for i, name := range []string{"min_assign_cost", "max_assign_cost", "min_done_cost", "max_done_cost"} {
+		if costs[i], err = strconv.Atoi(r.Form.Get(name)); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("bad " + name + ": " + err.Error()))
+			return
+		}
+	}
+	var effectiveFrom time.Time
+	if v := r.Form.Get("effective_from"); v != "" {
+		if effectiveFrom, err = time.Parse(pricingTimeLayout, v); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("bad effective_from: " + err.Error()))
+			return
+		}
+	}
+
+	p, err := db.NewPricingPolicy(costs[0], costs[1], costs[2], costs[3], effectiveFrom, r.Form.Get("reason"), user.PublicID)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err := srv.dbConn.CreatePricingPolicy(p); err != nil {
+		log.Println("failed to create pricing policy", err)
+		internalError(w)
+		return
+	}
+
+	log.Println("pricing policy", p, "added by", user.PublicID, "effective from", p.EffectiveFrom)
+	http.Redirect(w, r, "/pricing", http.StatusSeeOther)
+}
+
+// requestedDay parses the optional ?day=YYYY-MM-DD query param, defaulting to today.
+func requestedDay(r *http.Request) (time.Time, error) {
+	day := r.URL.Query().Get("day")
+	if day == "" {
+		return time.Now().Truncate(db.BillingCycleDuration), nil
+	}
+	return time.Parse(dayLayout, day)
+}
+
+func cycleIDs(bcs []db.BillingCycle) []uuid.UUID {
+	var ids []uuid.UUID
+	for _, bc := range bcs {
+		ids = append(ids, bc.PublicID)
+	}
+	return ids
+}
+
 func oauth(w http.ResponseWriter, r *http.Request) {
 	r.ParseForm()
 
@@ -301,38 +540,68 @@ func login(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, u, http.StatusFound)
 }
 
-func (srv *Server) closeBillingCycle() error {
-	tx := &db.Transaction{
-		PublicID: uuid.New(),
-		OwnerID:  srv.bcId,
-		Cost:     0,
-		Type:     db.TxType_MakePayment,
-	}
-	if err := srv.dbConn.CreateTransaction(tx); err != nil {
-		return err
-	}
-	if err := srv.producer.TxCreatedMsg(*tx); err != nil {
-		log.Println("failed to publish!", err)
-	}
-
-	users, err := srv.dbConn.GetAllAccounts()
-	if err != nil {
-		return err
-	}
+// closeBillingCycle pays out every positive balance and closes bc in a
+// single database transaction, so a failed close leaves no partial payouts.
+func (srv *Server) closeBillingCycle(bc *db.BillingCycle) error {
+	var next *db.BillingCycle
+	err := srv.dbConn.WithTx(func(conn db.Connection) error {
+		// lock the cycle first: postings take it before the worker accounts
+		if err := conn.LockBillingCycle(bc); err != nil {
+			return err
+		}
 
-	for _, user := range users {
-		user.TransactionLog = append(user.TransactionLog, tx.PublicID.String())
-		// TODO payout
-		if err := srv.dbConn.SaveAccount(&user); err != nil {
+		users, err := conn.GetAllAccounts()
+		if err != nil {
 			return err
 		}
-	}
 
-	bc, err := srv.dbConn.GetBillingCycle(srv.bcId.String())
+		payday := bc.StartedAt.Format(dayLayout)
+		meta := events.Meta{CorrelationID: uuid.NewString()}
+		for _, user := range users {
+			// frozen accounts were settled when they were deleted
+			if user.Frozen() {
+				continue
+			}
+			balance, err := conn.LockAccountBalance(user.PublicID, bc)
+			if err != nil {
+				return err
+			}
+			// negative balance is carried over to the next cycle
+			if balance <= 0 {
+				continue
+			}
+
+			tx := &db.Transaction{
+				PublicID:       uuid.New(),
+				OwnerID:        user.PublicID,
+				BillingCycleID: bc.PublicID,
+				Cost:           balance,
+				Type:           db.TxType_MakePayment,
+				Description:    fmt.Sprintf("payout for %s", payday),
+				// the payout settles the cycle as of its end
+				EventTime: bc.EndedAt,
+			}
+			if err := conn.CreateTransaction(tx); err != nil {
+				return err
+			}
+			if err := conn.PostTransaction(tx); err != nil {
+				return err
+			}
+			if err := srv.producer.TxCreatedMsg(conn, meta, *tx); err != nil {
+				return err
+			}
+			if err := srv.producer.PaymentDoneMsg(conn, meta, *tx); err != nil {
+				return err
+			}
+		}
+
+		next, err = conn.CloseBillingCycle(bc)
+		return err
+	})
 	if err != nil {
 		return err
 	}
-	bc.TransactionLog = append(bc.TransactionLog, tx.PublicID.String())
-	bc.DaySum = 0
-	return srv.dbConn.SaveBillingCycle(bc)
+
+	log.Println("billing cycle", bc.PublicID, "closed, total", bc.Total, "next", next.PublicID)
+	return nil
 }
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"billing/db"
+	"strings"
+	"testing"
+	"text/template"
+)
+
+func TestHomeEscapesDescriptions(t *testing.T) {
+	tmpl := template.Must(template.ParseFiles("templates/home.html"))
+
+	var out strings.Builder
+	err := tmpl.ExecuteTemplate(&out, "home", struct {
+		Transactions []db.Transaction
+		Balance      int
+		Day          string
+	}{
+		Transactions: []db.Transaction{{Description: `[POPUG-1] <script>alert("hi")</script>`}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(out.String(), "<script>") {
+		t.Errorf("description is not escaped:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "[POPUG-1] &lt;script&gt;") {
+		t.Errorf("description is missing:\n%s", out.String())
+	}
+}
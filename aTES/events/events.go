@@ -0,0 +1,97 @@
+// Package events holds the event envelope shared by the aTES services and the
+// event names, topics and versioned payloads generated from aTES/schemas.
+package events
+
+//go:generate go run ./gen -schemas ../schemas -out events_gen.go
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Headers set on every published event, so consumers can tell which copy of
+// a dual-published event is the one they understand without parsing it.
+const (
+	HeaderEventName     = "event_name"
+	HeaderEventVersion  = "event_version"
+	HeaderEventVersions = "event_versions"
+)
+
+// Event is the envelope of every event. Data is one of the generated
+// payloads, e.g. TaskCreatedV2, in the version given by EventVersion.
+//
+// CorrelationID is shared by all the events that follow from one user
+// action, CausationID is the id of the event this one was produced for.
+type Event struct {
+	EventID       string          `json:"event_id"`
+	EventVersion  int64           `json:"event_version"`
+	EventTime     string          `json:"event_time"`
+	Producer      string          `json:"producer"`
+	EventName     string          `json:"event_name"`
+	CorrelationID string          `json:"correlation_id,omitempty"`
+	CausationID   string          `json:"causation_id,omitempty"`
+	Data          json.RawMessage `json:"data"`
+}
+
+func (e *Event) String() string {
+	return fmt.Sprintf("%s v%d id=%s producer=%s correlation=%s causation=%s",
+		e.EventName, e.EventVersion, e.EventID, e.Producer, e.CorrelationID, e.CausationID)
+}
+
+// legacyTimeLayout is the time.Time.String format events used to be produced
+// with, before event_time became RFC3339.
+const legacyTimeLayout = "2006-01-02 15:04:05.999999999 -0700 MST"
+
+// Time is when the event happened according to its producer.
+func (e *Event) Time() (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, e.EventTime); err == nil {
+		return t, nil
+	}
+	legacy := e.EventTime
+	// drop the monotonic clock reading, e.g. " m=+0.000000001"
+	if i := strings.Index(legacy, " m="); i >= 0 {
+		legacy = legacy[:i]
+	}
+	t, err := time.Parse(legacyTimeLayout, legacy)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("bad event_time %q of %s: %w", e.EventTime, e.EventID, err)
+	}
+	return t, nil
+}
+
+// Meta links a new event to what caused it. Events caused by a user action
+// or a scheduled job start a new correlation and have no causation id.
+type Meta struct {
+	CorrelationID string
+	CausationID   string
+}
+
+// CausedBy is the meta of events produced while handling evt. Events from
+// producers that don't set a correlation id start one with their own id.
+func CausedBy(evt *Event) Meta {
+	correlationID := evt.CorrelationID
+	if correlationID == "" {
+		correlationID = evt.EventID
+	}
+	return Meta{CorrelationID: correlationID, CausationID: evt.EventID}
+}
+
+// New wraps data into an envelope of the given event name and version.
+func New(eventID, producer, evtName string, version int, meta Meta, data interface{}) (*Event, error) {
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Event{
+		EventID:       eventID,
+		EventVersion:  int64(version),
+		EventTime:     time.Now().UTC().Format(time.RFC3339),
+		Producer:      producer,
+		EventName:     evtName,
+		CorrelationID: meta.CorrelationID,
+		CausationID:   meta.CausationID,
+		Data:          raw,
+	}, nil
+}
@@ -0,0 +1,274 @@
+// Code generated by events/gen from aTES/schemas. DO NOT EDIT.
+
+package events
+
+const (
+	AccountEventsTopic     = "accounts"
+	AccountCUDsTopic       = "accounts-stream"
+	TaskPriceEventsTopic   = "task-prices"
+	TaskPriceCUDsTopic     = "task-prices-stream"
+	TaskEventsTopic        = "tasks"
+	TaskCUDsTopic          = "tasks-stream"
+	TransactionEventsTopic = "transactions"
+	TransactionCUDsTopic   = "transactions-stream"
+)
+
+const (
+	AccountCreatedEvt         = "AccountCreated"
+	AccountDeletedEvt         = "AccountDeleted"
+	AccountRoleChangedEvt     = "AccountRoleChanged"
+	AccountUpdatedEvt         = "AccountUpdated"
+	TaskAssignedEvt           = "TaskAssigned"
+	TaskCompletedEvt          = "TaskCompleted"
+	TaskCreatedEvt            = "TaskCreated"
+	TaskDeletedEvt            = "TaskDeleted"
+	TaskPricedEvt             = "TaskPriced"
+	TaskRestoredEvt           = "TaskRestored"
+	TaskUpdatedEvt            = "TaskUpdated"
+	TransactionAppliedEvt     = "TransactionApplied"
+	TransactionCreatedEvt     = "TransactionCreated"
+	TransactionPaymentDoneEvt = "TransactionPaymentDone"
+	TransactionUpdatedEvt     = "TransactionUpdated"
+)
+
+// TopicByEvt is the topic every event is published to.
+var TopicByEvt = map[string]string{
+	AccountCreatedEvt:         AccountCUDsTopic,
+	AccountDeletedEvt:         AccountCUDsTopic,
+	AccountRoleChangedEvt:     AccountEventsTopic,
+	AccountUpdatedEvt:         AccountCUDsTopic,
+	TaskAssignedEvt:           TaskEventsTopic,
+	TaskCompletedEvt:          TaskEventsTopic,
+	TaskCreatedEvt:            TaskCUDsTopic,
+	TaskDeletedEvt:            TaskCUDsTopic,
+	TaskPricedEvt:             TaskPriceEventsTopic,
+	TaskRestoredEvt:           TaskCUDsTopic,
+	TaskUpdatedEvt:            TaskCUDsTopic,
+	TransactionAppliedEvt:     TransactionEventsTopic,
+	TransactionCreatedEvt:     TransactionCUDsTopic,
+	TransactionPaymentDoneEvt: TransactionEventsTopic,
+	TransactionUpdatedEvt:     TransactionCUDsTopic,
+}
+
+// SchemaByEvt is the schema registry name of every event.
+var SchemaByEvt = map[string]string{
+	AccountCreatedEvt:         "accounts.created",
+	AccountDeletedEvt:         "accounts.deleted",
+	AccountRoleChangedEvt:     "accounts.role_changed",
+	AccountUpdatedEvt:         "accounts.updated",
+	TaskAssignedEvt:           "tasks.assigned",
+	TaskCompletedEvt:          "tasks.completed",
+	TaskCreatedEvt:            "tasks.created",
+	TaskDeletedEvt:            "tasks.deleted",
+	TaskPricedEvt:             "task-prices.priced",
+	TaskRestoredEvt:           "tasks.restored",
+	TaskUpdatedEvt:            "tasks.updated",
+	TransactionAppliedEvt:     "transactions.applied",
+	TransactionCreatedEvt:     "transactions.created",
+	TransactionPaymentDoneEvt: "transactions.paymentdone",
+	TransactionUpdatedEvt:     "transactions.updated",
+}
+
+// VersionsByEvt are the schema versions of every event.
+var VersionsByEvt = map[string][]int{
+	AccountCreatedEvt:         {1, 2},
+	AccountDeletedEvt:         {1},
+	AccountRoleChangedEvt:     {1},
+	AccountUpdatedEvt:         {1},
+	TaskAssignedEvt:           {1, 2},
+	TaskCompletedEvt:          {1, 2},
+	TaskCreatedEvt:            {1, 2},
+	TaskDeletedEvt:            {1, 2},
+	TaskPricedEvt:             {1},
+	TaskRestoredEvt:           {1},
+	TaskUpdatedEvt:            {1, 2},
+	TransactionAppliedEvt:     {1},
+	TransactionCreatedEvt:     {1},
+	TransactionPaymentDoneEvt: {1},
+	TransactionUpdatedEvt:     {1},
+}
+
+// AccountCreatedV1 is the data of Accounts.Created.v1.
+type AccountCreatedV1 struct {
+	PublicID string `json:"public_id"`
+	Email    string `json:"email"`
+	FullName string `json:"full_name,omitempty"`
+	Position string `json:"position,omitempty"`
+}
+
+// AccountCreatedV2 is the data of Accounts.Created.v2.
+type AccountCreatedV2 struct {
+	PublicID  string `json:"public_id"`
+	Email     string `json:"email"`
+	FirstName string `json:"first_name,omitempty"`
+	LastName  string `json:"last_name,omitempty"`
+	Position  string `json:"position,omitempty"`
+}
+
+// AccountDeletedV1 is the data of Accounts.Deleted.v1.
+type AccountDeletedV1 struct {
+	PublicID string `json:"public_id"`
+}
+
+// AccountRoleChangedV1 is the data of Accounts.RoleChanged.v1.
+type AccountRoleChangedV1 struct {
+	PublicID string `json:"public_id"`
+	Role     string `json:"role"`
+}
+
+// AccountUpdatedV1 is the data of Accounts.Updated.v1.
+type AccountUpdatedV1 struct {
+	PublicID string `json:"public_id"`
+	Email    string `json:"email,omitempty"`
+	FullName string `json:"full_name,omitempty"`
+	Position string `json:"position,omitempty"`
+}
+
+// TaskAssignedV1 is the data of Tasks.Assigned.v1.
+type TaskAssignedV1 struct {
+	PublicID    string `json:"public_id"`
+	OwnerID     string `json:"owner_id"`
+	Status      int    `json:"status"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+}
+
+// TaskAssignedV2 is the data of Tasks.Assigned.v2.
+type TaskAssignedV2 struct {
+	PublicID    string `json:"public_id"`
+	OwnerID     string `json:"owner_id"`
+	Status      int    `json:"status"`
+	Title       string `json:"title"`
+	JiraID      string `json:"jira_id"`
+	Description string `json:"description,omitempty"`
+}
+
+// TaskCompletedV1 is the data of Tasks.Completed.v1.
+type TaskCompletedV1 struct {
+	PublicID    string `json:"public_id"`
+	OwnerID     string `json:"owner_id"`
+	Status      int    `json:"status"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+}
+
+// TaskCompletedV2 is the data of Tasks.Completed.v2.
+type TaskCompletedV2 struct {
+	PublicID    string `json:"public_id"`
+	OwnerID     string `json:"owner_id"`
+	Status      int    `json:"status"`
+	Title       string `json:"title"`
+	JiraID      string `json:"jira_id"`
+	Description string `json:"description,omitempty"`
+}
+
+// TaskCreatedV1 is the data of Tasks.Created.v1.
+type TaskCreatedV1 struct {
+	PublicID    string `json:"public_id"`
+	OwnerID     string `json:"owner_id"`
+	Status      int    `json:"status"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+}
+
+// TaskCreatedV2 is the data of Tasks.Created.v2.
+type TaskCreatedV2 struct {
+	PublicID    string `json:"public_id"`
+	OwnerID     string `json:"owner_id"`
+	Status      int    `json:"status"`
+	Title       string `json:"title"`
+	JiraID      string `json:"jira_id"`
+	Description string `json:"description,omitempty"`
+}
+
+// TaskDeletedV1 is the data of Tasks.Deleted.v1.
+type TaskDeletedV1 struct {
+	PublicID    string `json:"public_id"`
+	OwnerID     string `json:"owner_id"`
+	Status      int    `json:"status"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+}
+
+// TaskDeletedV2 is the data of Tasks.Deleted.v2.
+type TaskDeletedV2 struct {
+	PublicID    string `json:"public_id"`
+	OwnerID     string `json:"owner_id"`
+	Status      int    `json:"status"`
+	Title       string `json:"title"`
+	JiraID      string `json:"jira_id"`
+	Description string `json:"description,omitempty"`
+}
+
+// TaskPricedV1 is the data of TaskPrices.Priced.v1.
+type TaskPricedV1 struct {
+	PublicID      string `json:"public_id"`
+	AssignCost    int    `json:"assign_cost"`
+	DoneCost      int    `json:"done_cost"`
+	PolicyVersion int    `json:"policy_version"`
+}
+
+// TaskRestoredV1 is the data of Tasks.Restored.v1.
+type TaskRestoredV1 struct {
+	PublicID    string `json:"public_id"`
+	OwnerID     string `json:"owner_id"`
+	Status      int    `json:"status"`
+	Title       string `json:"title"`
+	JiraID      string `json:"jira_id"`
+	Description string `json:"description,omitempty"`
+}
+
+// TaskUpdatedV1 is the data of Tasks.Updated.v1.
+type TaskUpdatedV1 struct {
+	PublicID    string `json:"public_id"`
+	OwnerID     string `json:"owner_id"`
+	Status      int    `json:"status"`
+	Title       string `json:"title"`
+	Description string `json:"description,omitempty"`
+}
+
+// TaskUpdatedV2 is the data of Tasks.Updated.v2.
+type TaskUpdatedV2 struct {
+	PublicID    string `json:"public_id"`
+	OwnerID     string `json:"owner_id"`
+	Status      int    `json:"status"`
+	Title       string `json:"title"`
+	JiraID      string `json:"jira_id"`
+	Description string `json:"description,omitempty"`
+}
+
+// TransactionAppliedV1 is the data of Transaction.Applied.v1.
+type TransactionAppliedV1 struct {
+	PublicID    string `json:"public_id"`
+	OwnerID     string `json:"owner_id"`
+	Cost        int    `json:"cost"`
+	Type        int    `json:"type"`
+	Description string `json:"description,omitempty"`
+}
+
+// TransactionCreatedV1 is the data of Transaction.Created.v1.
+type TransactionCreatedV1 struct {
+	PublicID    string `json:"public_id"`
+	OwnerID     string `json:"owner_id"`
+	Cost        int    `json:"cost"`
+	Type        int    `json:"type"`
+	Description string `json:"description,omitempty"`
+	Status      *int   `json:"status,omitempty"`
+}
+
+// TransactionPaymentDoneV1 is the data of Transaction.PaymentDone.v1.
+type TransactionPaymentDoneV1 struct {
+	PublicID string `json:"public_id"`
+	OwnerID  string `json:"owner_id"`
+	Cost     int    `json:"cost"`
+}
+
+// TransactionUpdatedV1 is the data of Transaction.Updated.v1.
+type TransactionUpdatedV1 struct {
+	PublicID    string `json:"public_id"`
+	OwnerID     string `json:"owner_id"`
+	Cost        int    `json:"cost"`
+	Type        int    `json:"type"`
+	Description string `json:"description,omitempty"`
+	Status      int    `json:"status,omitempty"`
+}
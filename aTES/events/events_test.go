@@ -0,0 +1,62 @@
+package events
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	evt, err := New("evt-2", "billing", TaskPricedEvt, 1, Meta{CorrelationID: "evt-1", CausationID: "evt-1"}, TaskPricedV1{PublicID: "task-1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if evt.EventID != "evt-2" || evt.Producer != "billing" || evt.EventName != TaskPricedEvt || evt.EventVersion != 1 {
+		t.Errorf("got %s", evt)
+	}
+	if evt.CorrelationID != "evt-1" || evt.CausationID != "evt-1" {
+		t.Errorf("got correlation %q, causation %q", evt.CorrelationID, evt.CausationID)
+	}
+	if _, err := time.Parse(time.RFC3339, evt.EventTime); err != nil {
+		t.Errorf("event_time %q is not RFC3339", evt.EventTime)
+	}
+}
+
+func TestCausedBy(t *testing.T) {
+	// the first event of a user action starts the correlation
+	root := &Event{EventID: "evt-1"}
+	meta := CausedBy(root)
+	if meta.CorrelationID != "evt-1" || meta.CausationID != "evt-1" {
+		t.Errorf("caused by a root event: got %+v", meta)
+	}
+
+	child := &Event{EventID: "evt-2", CorrelationID: "evt-1", CausationID: "evt-1"}
+	meta = CausedBy(child)
+	if meta.CorrelationID != "evt-1" || meta.CausationID != "evt-2" {
+		t.Errorf("caused by a caused event: got %+v", meta)
+	}
+}
+
+func TestTime(t *testing.T) {
+	want := time.Date(2022, 5, 14, 10, 30, 15, 0, time.UTC)
+	for _, eventTime := range []string{
+		"2022-05-14T10:30:15Z",
+		"2022-05-14T12:30:15+02:00",
+		// the time.Time.String format events were produced with before
+		"2022-05-14 10:30:15 +0000 UTC",
+		"2022-05-14 10:30:15.000000000 +0000 UTC m=+12.345678901",
+	} {
+		evt := &Event{EventID: "evt-1", EventTime: eventTime}
+		got, err := evt.Time()
+		if err != nil {
+			t.Errorf("%q: %v", eventTime, err)
+			continue
+		}
+		if !got.Equal(want) {
+			t.Errorf("%q: got %s, want %s", eventTime, got, want)
+		}
+	}
+
+	if _, err := (&Event{EventID: "evt-1", EventTime: "yesterday"}).Time(); err == nil {
+		t.Error("bad event_time parsed")
+	}
+}
@@ -0,0 +1,348 @@
+// Command gen generates event names, topics and versioned payload structs
+// from the event schemas, laid out as <entity>/<event>/<version>.json.
+//
+// Every entity gets two topics: "<entity>-stream" for the created, updated,
+// deleted and restored (CUD) events and "<entity>" for the business events.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var cudEvents = map[string]bool{"created": true, "updated": true, "deleted": true, "restored": true}
+
+// initialisms are kept upper case in the generated field names.
+var initialisms = map[string]bool{"id": true, "url": true, "uuid": true, "api": true}
+
+type schemaFile struct {
+	entity, event string
+	version       int
+	evtName       string
+	schema        map[string]interface{}
+}
+
+func main() {
+	dir := flag.String("schemas", "../schemas", "event schemas directory")
+	out := flag.String("out", "events_gen.go", "output file")
+	flag.Parse()
+
+	files, err := load(*dir)
+	if err != nil {
+		fmt.Println("load schemas failed:", err)
+		os.Exit(1)
+	}
+
+	src, err := generate(files)
+	if err != nil {
+		fmt.Println("generate failed:", err)
+		os.Exit(1)
+	}
+	if err := ioutil.WriteFile(*out, src, 0644); err != nil {
+		fmt.Println("write failed:", err)
+		os.Exit(1)
+	}
+}
+
+func load(dir string) ([]schemaFile, error) {
+	var files []schemaFile
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		parts := strings.Split(filepath.ToSlash(rel), "/")
+		if len(parts) != 3 {
+			return fmt.Errorf("%s: expected <entity>/<event>/<version>.json", path)
+		}
+		version, err := strconv.Atoi(strings.TrimSuffix(parts[2], ".json"))
+		if err != nil {
+			return fmt.Errorf("%s: file name is not a version: %w", path, err)
+		}
+
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var schema map[string]interface{}
+		if err := json.Unmarshal(raw, &schema); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		evtName, err := eventName(schema)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		files = append(files, schemaFile{parts[0], parts[1], version, evtName, schema})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].evtName != files[j].evtName {
+			return files[i].evtName < files[j].evtName
+		}
+		return files[i].version < files[j].version
+	})
+	return files, nil
+}
+
+// eventName is the only value of properties.event_name.enum.
+func eventName(schema map[string]interface{}) (string, error) {
+	props, _ := schema["properties"].(map[string]interface{})
+	en, _ := props["event_name"].(map[string]interface{})
+	enum, _ := en["enum"].([]interface{})
+	if len(enum) != 1 {
+		return "", fmt.Errorf("event_name is not a single value enum")
+	}
+	name, ok := enum[0].(string)
+	if !ok || name == "" {
+		return "", fmt.Errorf("event_name is not a string")
+	}
+	return name, nil
+}
+
+func generate(files []schemaFile) ([]byte, error) {
+	var b bytes.Buffer
+
+	entities := map[string]bool{}
+	evtEntity := map[string]string{}
+	evtSchema := map[string]string{}
+	evtCUD := map[string]bool{}
+	versions := map[string][]int{}
+	var evtNames []string
+	for _, f := range files {
+		entities[f.entity] = true
+		if _, ok := evtEntity[f.evtName]; !ok {
+			evtNames = append(evtNames, f.evtName)
+		}
+		evtEntity[f.evtName] = f.entity
+		evtSchema[f.evtName] = f.entity + "." + f.event
+		evtCUD[f.evtName] = cudEvents[f.event]
+		versions[f.evtName] = append(versions[f.evtName], f.version)
+	}
+
+	b.WriteString("const (\n")
+	for _, entity := range sortedKeys(entities) {
+		fmt.Fprintf(&b, "%s = %q\n", topicConst(entity, false), entity)
+		fmt.Fprintf(&b, "%s = %q\n", topicConst(entity, true), entity+"-stream")
+	}
+	b.WriteString(")\n\n")
+
+	b.WriteString("const (\n")
+	for _, name := range evtNames {
+		fmt.Fprintf(&b, "%sEvt = %q\n", name, name)
+	}
+	b.WriteString(")\n\n")
+
+	b.WriteString("// TopicByEvt is the topic every event is published to.\n")
+	b.WriteString("var TopicByEvt = map[string]string{\n")
+	for _, name := range evtNames {
+		fmt.Fprintf(&b, "%sEvt: %s,\n", name, topicConst(evtEntity[name], evtCUD[name]))
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// SchemaByEvt is the schema registry name of every event.\n")
+	b.WriteString("var SchemaByEvt = map[string]string{\n")
+	for _, name := range evtNames {
+		fmt.Fprintf(&b, "%sEvt: %q,\n", name, evtSchema[name])
+	}
+	b.WriteString("}\n\n")
+
+	b.WriteString("// VersionsByEvt are the schema versions of every event.\n")
+	b.WriteString("var VersionsByEvt = map[string][]int{\n")
+	for _, name := range evtNames {
+		vs := make([]string, 0, len(versions[name]))
+		for _, v := range versions[name] {
+			vs = append(vs, strconv.Itoa(v))
+		}
+		fmt.Fprintf(&b, "%sEvt: {%s},\n", name, strings.Join(vs, ", "))
+	}
+	b.WriteString("}\n")
+
+	for _, f := range files {
+		if err := writeStruct(&b, f); err != nil {
+			return nil, fmt.Errorf("%s/%s/%d.json: %w", f.entity, f.event, f.version, err)
+		}
+	}
+
+	var head bytes.Buffer
+	head.WriteString("// Code generated by events/gen from aTES/schemas. DO NOT EDIT.\n\n")
+	head.WriteString("package events\n\n")
+	if bytes.Contains(b.Bytes(), []byte("json.RawMessage")) {
+		head.WriteString("import \"encoding/json\"\n\n")
+	}
+	head.Write(b.Bytes())
+
+	src, err := format.Source(head.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format: %w\n%s", err, b.String())
+	}
+	return src, nil
+}
+
+func topicConst(entity string, cud bool) string {
+	name := goName(strings.TrimSuffix(entity, "s"))
+	if cud {
+		return name + "CUDsTopic"
+	}
+	return name + "EventsTopic"
+}
+
+// writeStruct writes the data of the event version as <EventName>V<version>.
+func writeStruct(b *bytes.Buffer, f schemaFile) error {
+	props, _ := f.schema["properties"].(map[string]interface{})
+	data, _ := props["data"].(map[string]interface{})
+	data = resolve(f.schema, data)
+	if data == nil {
+		return fmt.Errorf("no data definition")
+	}
+
+	title, _ := f.schema["title"].(string)
+	fmt.Fprintf(b, "\n// %sV%d is the data of %s.\n", f.evtName, f.version, title)
+	fmt.Fprintf(b, "type %sV%d struct {\n", f.evtName, f.version)
+
+	fields, _ := data["properties"].(map[string]interface{})
+	required := map[string]bool{}
+	req, _ := data["required"].([]interface{})
+	for _, r := range req {
+		if s, ok := r.(string); ok {
+			required[s] = true
+		}
+	}
+	for _, name := range fieldOrder(fields, req) {
+		field, _ := fields[name].(map[string]interface{})
+		typ, err := goType(resolve(f.schema, field))
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		tag := name
+		if !required[name] {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(b, "%s %s `json:%q`\n", goName(name), typ, tag)
+	}
+	b.WriteString("}\n")
+	return nil
+}
+
+// fieldOrder lists the required fields in the schema order and then the
+// optional ones sorted by name.
+func fieldOrder(fields map[string]interface{}, required []interface{}) []string {
+	var order []string
+	seen := map[string]bool{}
+	for _, r := range required {
+		if s, ok := r.(string); ok && !seen[s] {
+			if _, ok := fields[s]; ok {
+				order = append(order, s)
+				seen[s] = true
+			}
+		}
+	}
+	for _, name := range sortedKeys(fields) {
+		if !seen[name] {
+			order = append(order, name)
+		}
+	}
+	return order
+}
+
+// goType maps a schema type to go. Nullable strings become plain strings,
+// other nullable types become pointers.
+func goType(field map[string]interface{}) (string, error) {
+	var types []string
+	nullable := false
+	switch t := field["type"].(type) {
+	case string:
+		types = []string{t}
+	case []interface{}:
+		for _, v := range t {
+			s, _ := v.(string)
+			if s == "null" {
+				nullable = true
+				continue
+			}
+			types = append(types, s)
+		}
+	}
+	if len(types) != 1 {
+		return "json.RawMessage", nil
+	}
+
+	var typ string
+	switch types[0] {
+	case "string":
+		return "string", nil
+	case "integer":
+		typ = "int"
+	case "number":
+		typ = "float64"
+	case "boolean":
+		typ = "bool"
+	case "object", "array":
+		return "json.RawMessage", nil
+	default:
+		return "", fmt.Errorf("unsupported type %q", types[0])
+	}
+	if nullable {
+		typ = "*" + typ
+	}
+	return typ, nil
+}
+
+// resolve follows local "#/..." references.
+func resolve(root, node map[string]interface{}) map[string]interface{} {
+	for i := 0; node != nil && i < 32; i++ {
+		ref, ok := node["$ref"].(string)
+		if !ok || !strings.HasPrefix(ref, "#/") {
+			return node
+		}
+		var cur interface{} = root
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			m, _ := cur.(map[string]interface{})
+			cur = m[part]
+		}
+		node, _ = cur.(map[string]interface{})
+	}
+	return node
+}
+
+// goName turns snake_case into an exported go name, "jira_id" to "JiraID".
+func goName(s string) string {
+	var b strings.Builder
+	for _, part := range strings.FieldsFunc(s, func(r rune) bool { return r == '_' || r == '-' || r == '.' }) {
+		if initialisms[part] {
+			b.WriteString(strings.ToUpper(part))
+			continue
+		}
+		b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+	}
+	return b.String()
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestGoName(t *testing.T) {
+	for in, want := range map[string]string{
+		"jira_id":       "JiraID",
+		"public_id":     "PublicID",
+		"title":         "Title",
+		"billing-cycle": "BillingCycle",
+		"task.price":    "TaskPrice",
+	} {
+		if got := goName(in); got != want {
+			t.Errorf("goName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestGoType(t *testing.T) {
+	tests := []struct {
+		typ  interface{}
+		want string
+	}{
+		{typ: "string", want: "string"},
+		{typ: []interface{}{"string", "null"}, want: "string"},
+		{typ: "integer", want: "int"},
+		{typ: []interface{}{"integer", "null"}, want: "*int"},
+		{typ: "number", want: "float64"},
+		{typ: "boolean", want: "bool"},
+		{typ: "object", want: "json.RawMessage"},
+		{typ: []interface{}{"string", "integer"}, want: "json.RawMessage"},
+	}
+	for _, tt := range tests {
+		got, err := goType(map[string]interface{}{"type": tt.typ})
+		if err != nil || got != tt.want {
+			t.Errorf("goType(%v) = %q, %v, want %q", tt.typ, got, err, tt.want)
+		}
+	}
+
+	if _, err := goType(map[string]interface{}{"type": "date"}); err == nil {
+		t.Error("unsupported type accepted")
+	}
+}
+
+func TestFieldOrder(t *testing.T) {
+	fields := map[string]interface{}{"title": nil, "public_id": nil, "jira_id": nil, "description": nil}
+	got := fieldOrder(fields, []interface{}{"public_id", "title", "missing"})
+	want := []string{"public_id", "title", "description", "jira_id"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	root := map[string]interface{}{
+		"definitions": map[string]interface{}{
+			"v1": map[string]interface{}{"$ref": "#/definitions/v2"},
+			"v2": map[string]interface{}{"type": "object"},
+		},
+	}
+	got := resolve(root, map[string]interface{}{"$ref": "#/definitions/v1"})
+	if got["type"] != "object" {
+		t.Errorf("resolved to %v", got)
+	}
+}
+
+// TestGenerated fails when the schemas change without go generate.
+func TestGenerated(t *testing.T) {
+	files, err := load("../../schemas")
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := generate(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := ioutil.ReadFile("../events_gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(src, current) {
+		t.Error("events_gen.go is out of date, run go generate in aTES/events")
+	}
+}
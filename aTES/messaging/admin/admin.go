@@ -0,0 +1,92 @@
+package admin
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// Partitions is how many partitions every topic is expected to have. Events
+// are keyed by entity id, so changing it reshuffles keys between partitions
+// and breaks per-entity ordering of the events already in the topic.
+const Partitions = 3
+
+type TopicSpec struct {
+	Name       string
+	Partitions int
+}
+
+// Topics makes the specs of the named topics, a topic both produced to and
+// consumed from is listed once.
+func Topics(names ...string) []TopicSpec {
+	specs := make([]TopicSpec, 0, len(names))
+	seen := map[string]bool{}
+	for _, name := range names {
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		specs = append(specs, TopicSpec{Name: name, Partitions: Partitions})
+	}
+	return specs
+}
+
+// EnsureTopics creates the missing topics and fails if an existing topic
+// has a different number of partitions than expected.
+func EnsureTopics(specs []TopicSpec) error {
+	a, err := kafka.NewAdminClient(&kafka.ConfigMap{
+		"bootstrap.servers": "broker:29092",
+	})
+	if err != nil {
+		return fmt.Errorf("admin client failed: %w", err)
+	}
+	defer a.Close()
+
+	md, err := a.GetMetadata(nil, true, 10000)
+	if err != nil {
+		return fmt.Errorf("get metadata failed: %w", err)
+	}
+
+	var (
+		missing    []kafka.TopicSpecification
+		mismatches []string
+	)
+	for _, spec := range specs {
+		tm, ok := md.Topics[spec.Name]
+		if !ok || tm.Error.Code() == kafka.ErrUnknownTopicOrPart {
+			missing = append(missing, kafka.TopicSpecification{
+				Topic:             spec.Name,
+				NumPartitions:     spec.Partitions,
+				ReplicationFactor: 1,
+			})
+			continue
+		}
+		if len(tm.Partitions) != spec.Partitions {
+			mismatches = append(mismatches,
+				fmt.Sprintf("%s has %d partitions, expected %d", spec.Name, len(tm.Partitions), spec.Partitions))
+		}
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("unexpected topic layout: %s", strings.Join(mismatches, "; "))
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+	res, err := a.CreateTopics(ctx, missing)
+	if err != nil {
+		return fmt.Errorf("create topics failed: %w", err)
+	}
+	for _, r := range res {
+		if code := r.Error.Code(); code != kafka.ErrNoError && code != kafka.ErrTopicAlreadyExists {
+			return fmt.Errorf("create topic %s failed: %w", r.Topic, r.Error)
+		}
+		fmt.Println("created topic", r.Topic)
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+// Package deadletter keeps the incoming events a service failed to process.
+package deadletter
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// DeadLetter is an incoming event that could not be processed. Admins can
+// fix its payload and re-drive it through the consumer.
+type DeadLetter struct {
+	gorm.Model
+	PublicID   uuid.UUID  `json:"public_id"`
+	Topic      string     `json:"topic"`
+	Key        string     `json:"key"`
+	Payload    string     `json:"payload"`
+	Headers    string     `json:"headers"`
+	Error      string     `json:"error"`
+	Stack      string     `json:"stack"`
+	Attempts   int        `json:"attempts"`
+	Status     Status     `json:"status"`
+	RedrivenAt *time.Time `json:"redriven_at"`
+}
+
+type Status int
+
+const (
+	Status_Failed   Status = 0
+	Status_Redriven Status = 1
+)
+
+func (status Status) String() string {
+	switch status {
+	case Status_Redriven:
+		return "redriven"
+	default:
+		return "failed"
+	}
+}
+
+func Get(db *gorm.DB, id string) (*DeadLetter, error) {
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("parse id failed: %w", err)
+	}
+
+	var dl DeadLetter
+	res := db.Where(&DeadLetter{PublicID: uid}).First(&dl)
+	if res.Error != nil {
+		return nil, fmt.Errorf("get dead letter failed: %w", res.Error)
+	}
+	return &dl, nil
+}
+
+func GetFailed(db *gorm.DB) ([]DeadLetter, error) {
+	all := []DeadLetter{}
+	res := db.Where("status = ?", Status_Failed).Order("created_at desc").Find(&all)
+	if res.Error != nil {
+		return nil, fmt.Errorf("get dead letters failed: %s", res.Error)
+	}
+	return all, nil
+}
+
+func Create(db *gorm.DB, dl *DeadLetter) error {
+	if dl.PublicID == uuid.Nil {
+		dl.PublicID = uuid.New()
+	}
+	res := db.Create(dl)
+	if res.Error != nil {
+		return fmt.Errorf("dead letter create failed: %s", res.Error)
+	}
+	return nil
+}
+
+func Save(db *gorm.DB, dl *DeadLetter) error {
+	res := db.Save(dl)
+	if res.Error != nil {
+		return fmt.Errorf("dead letter save failed: %s", res.Error)
+	}
+	return nil
+}
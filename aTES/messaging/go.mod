@@ -0,0 +1,20 @@
+module messaging
+
+go 1.18
+
+require (
+	github.com/confluentinc/confluent-kafka-go v1.8.2
+	github.com/google/uuid v1.3.0
+	gorm.io/driver/sqlite v1.3.6
+	gorm.io/gorm v1.23.5
+)
+
+require (
+	github.com/jinzhu/inflection v1.0.0 // indirect
+	github.com/jinzhu/now v1.1.5 // indirect
+	github.com/mattn/go-sqlite3 v1.14.12 // indirect
+)
+
+require events v0.0.0
+
+replace events => ../events
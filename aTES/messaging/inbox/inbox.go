@@ -0,0 +1,45 @@
+// Package inbox deduplicates the events a service consumes.
+package inbox
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ProcessedEvent is an inbox record of an applied incoming event. It is
+// written in the same transaction as the change the event caused, so a
+// redelivered event is detected and skipped.
+type ProcessedEvent struct {
+	EventID     string `gorm:"primaryKey"`
+	EventName   string
+	Topic       string
+	ProcessedAt time.Time
+}
+
+// MarkProcessed records the event in the inbox and reports whether this is
+// the first time it is seen. A concurrent transaction marking the same event
+// blocks until the first one ends.
+func MarkProcessed(db *gorm.DB, eventID, eventName, topic string) (bool, error) {
+	res := db.Clauses(clause.OnConflict{DoNothing: true}).Create(&ProcessedEvent{
+		EventID:     eventID,
+		EventName:   eventName,
+		Topic:       topic,
+		ProcessedAt: time.Now(),
+	})
+	if res.Error != nil {
+		return false, fmt.Errorf("inbox mark failed: %s", res.Error)
+	}
+	return res.RowsAffected == 1, nil
+}
+
+func IsProcessed(db *gorm.DB, eventID string) (bool, error) {
+	var n int64
+	res := db.Model(&ProcessedEvent{}).Where("event_id = ?", eventID).Count(&n)
+	if res.Error != nil {
+		return false, fmt.Errorf("inbox lookup failed: %s", res.Error)
+	}
+	return n > 0, nil
+}
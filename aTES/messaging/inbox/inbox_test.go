@@ -0,0 +1,58 @@
+package inbox
+
+import (
+	"errors"
+	"messaging/internal/dbtest"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+func TestMarkProcessed(t *testing.T) {
+	db := dbtest.Open(t, &ProcessedEvent{})
+
+	first, err := MarkProcessed(db, "evt-1", "Task.Created", "tasks-stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := MarkProcessed(db, "evt-1", "Task.Created", "tasks-stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first || again {
+		t.Errorf("got first %v, redelivered %v, want true, false", first, again)
+	}
+
+	for id, want := range map[string]bool{"evt-1": true, "evt-2": false} {
+		seen, err := IsProcessed(db, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if seen != want {
+			t.Errorf("IsProcessed(%s) = %v, want %v", id, seen, want)
+		}
+	}
+}
+
+func TestMarkProcessedRollsBack(t *testing.T) {
+	db := dbtest.Open(t, &ProcessedEvent{})
+
+	// the event is not marked when the change it caused fails
+	err := db.Transaction(func(tx *gorm.DB) error {
+		if _, err := MarkProcessed(tx, "evt-1", "Task.Created", "tasks-stream"); err != nil {
+			return err
+		}
+		return errors.New("apply failed")
+	})
+	if err == nil {
+		t.Fatal("transaction did not fail")
+	}
+
+	first, err := MarkProcessed(db, "evt-1", "Task.Created", "tasks-stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !first {
+		t.Error("event of a rolled back transaction is marked processed")
+	}
+}
@@ -0,0 +1,161 @@
+// Package outbox keeps the events of a service in its database until they
+// are relayed to kafka.
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Message is an event waiting to be published to kafka. It is written in the
+// same transaction as the change it describes, so an event is never lost once
+// the change is committed.
+type Message struct {
+	ID            uint `gorm:"primarykey"`
+	CreatedAt     time.Time
+	Topic         string
+	Key           string
+	Headers       string // JSON object of kafka headers
+	Payload       []byte
+	Status        Status `gorm:"index"`
+	Attempts      int
+	LastError     string
+	NextAttemptAt time.Time
+	SentAt        *time.Time
+}
+
+func (Message) TableName() string {
+	return "outbox_messages"
+}
+
+type Status int
+
+const (
+	Status_Pending Status = 0
+	Status_Sent    Status = 1
+)
+
+func (status Status) String() string {
+	switch status {
+	case Status_Sent:
+		return "sent"
+	default:
+		return "pending"
+	}
+}
+
+// Add queues payload for topic using db, which is the transaction of the
+// change the event describes. Messages with the same key end up in the same
+// partition, so their consumers get them in order.
+func Add(db *gorm.DB, topic, key string, headers map[string]string, payload []byte) error {
+	encoded, err := json.Marshal(headers)
+	if err != nil {
+		return fmt.Errorf("encode outbox msg headers failed: %w", err)
+	}
+
+	res := db.Create(&Message{
+		Topic:         topic,
+		Key:           key,
+		Headers:       string(encoded),
+		Payload:       payload,
+		Status:        Status_Pending,
+		NextAttemptAt: time.Now(),
+	})
+	if res.Error != nil {
+		return fmt.Errorf("outbox msg create failed: %s", res.Error)
+	}
+	return nil
+}
+
+// Claim leases up to limit messages due for delivery, in the order they were
+// written. The claim stops at the first message that is not due, so a message
+// waiting for a retry holds back the ones after it. The lease moves the next
+// attempt of the claimed messages past its end and is committed at once:
+// other relays skip them while they are delivered outside of any transaction,
+// and the messages of a relay that dies mid-batch are picked up again once
+// the lease ends.
+func Claim(db *gorm.DB, limit int, lease time.Duration) ([]Message, error) {
+	claimed := []Message{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		all := []Message{}
+		res := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("status = ?", Status_Pending).
+			Order("id").
+			Limit(limit).
+			Find(&all)
+		if res.Error != nil {
+			return fmt.Errorf("get pending outbox msgs failed: %s", res.Error)
+		}
+
+		now := time.Now()
+		ids := []uint{}
+		for _, m := range all {
+			if m.NextAttemptAt.After(now) {
+				break
+			}
+			m.NextAttemptAt = now.Add(lease)
+			ids = append(ids, m.ID)
+			claimed = append(claimed, m)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		res = tx.Model(&Message{}).Where("id IN ?", ids).Update("next_attempt_at", now.Add(lease))
+		if res.Error != nil {
+			return fmt.Errorf("outbox msgs lease failed: %s", res.Error)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return claimed, nil
+}
+
+// Release ends the lease of claimed messages that were not delivered, so the
+// next claim picks them up without waiting for it.
+func Release(db *gorm.DB, msgs []Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+	ids := make([]uint, 0, len(msgs))
+	for _, m := range msgs {
+		ids = append(ids, m.ID)
+	}
+	res := db.Model(&Message{}).
+		Where("id IN ? AND status = ?", ids, Status_Pending).
+		Update("next_attempt_at", time.Now())
+	if res.Error != nil {
+		return fmt.Errorf("outbox msgs release failed: %s", res.Error)
+	}
+	return nil
+}
+
+func MarkSent(db *gorm.DB, m *Message) error {
+	now := time.Now()
+	m.Status = Status_Sent
+	m.SentAt = &now
+	m.Attempts++
+	m.LastError = ""
+	res := db.Save(m)
+	if res.Error != nil {
+		return fmt.Errorf("outbox msg save failed: %s", res.Error)
+	}
+	return nil
+}
+
+func MarkFailed(db *gorm.DB, m *Message, reason error, retryAt time.Time) error {
+	m.Attempts++
+	m.LastError = reason.Error()
+	m.NextAttemptAt = retryAt
+	res := db.Save(m)
+	if res.Error != nil {
+		return fmt.Errorf("outbox msg save failed: %s", res.Error)
+	}
+	return nil
+}
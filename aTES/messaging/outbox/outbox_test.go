@@ -0,0 +1,97 @@
+package outbox
+
+import (
+	"errors"
+	"messaging/internal/dbtest"
+	"testing"
+	"time"
+)
+
+func claimedKeys(t *testing.T, msgs []Message) []string {
+	t.Helper()
+	keys := []string{}
+	for _, m := range msgs {
+		keys = append(keys, m.Key)
+	}
+	return keys
+}
+
+func TestClaim(t *testing.T) {
+	db := dbtest.Open(t, &Message{})
+	for _, key := range []string{"task-1", "task-2", "task-3"} {
+		if err := Add(db, "tasks", key, map[string]string{"event_version": "2"}, []byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	claimed, err := Claim(db, 2, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := claimedKeys(t, claimed); len(got) != 2 || got[0] != "task-1" || got[1] != "task-2" {
+		t.Fatalf("claimed %v, want the first two in order", got)
+	}
+	if claimed[0].Headers != `{"event_version":"2"}` {
+		t.Errorf("headers = %s", claimed[0].Headers)
+	}
+
+	// leased messages hold back the ones after them
+	again, err := Claim(db, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 0 {
+		t.Errorf("claimed %v during the lease", claimedKeys(t, again))
+	}
+
+	if err := MarkSent(db, &claimed[0]); err != nil {
+		t.Fatal(err)
+	}
+	if err := Release(db, claimed); err != nil {
+		t.Fatal(err)
+	}
+	again, err = Claim(db, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := claimedKeys(t, again); len(got) != 2 || got[0] != "task-2" || got[1] != "task-3" {
+		t.Errorf("claimed %v after release, want the unsent ones", got)
+	}
+}
+
+func TestMarkFailed(t *testing.T) {
+	db := dbtest.Open(t, &Message{})
+	for _, key := range []string{"task-1", "task-2"} {
+		if err := Add(db, "tasks", key, nil, []byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	claimed, err := Claim(db, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := MarkFailed(db, &claimed[0], errors.New("broker down"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := Release(db, claimed[1:]); err != nil {
+		t.Fatal(err)
+	}
+
+	// a message waiting for its retry keeps the order of the ones after it
+	again, err := Claim(db, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(again) != 0 {
+		t.Errorf("claimed %v past a message waiting for retry", claimedKeys(t, again))
+	}
+
+	var m Message
+	if err := db.First(&m, claimed[0].ID).Error; err != nil {
+		t.Fatal(err)
+	}
+	if m.Attempts != 1 || m.LastError != "broker down" || m.Status != Status_Pending {
+		t.Errorf("got %d attempts, error %q, %s", m.Attempts, m.LastError, m.Status)
+	}
+}
@@ -0,0 +1,137 @@
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"gorm.io/gorm"
+)
+
+const (
+	relayBatchSize  = 100
+	relayIdle       = time.Second
+	relayLease      = time.Minute * 5
+	deliveryTimeout = time.Second * 30
+	maxRetryBackoff = time.Minute
+)
+
+// Relay publishes the outbox of a service to kafka.
+type Relay struct {
+	producer *kafka.Producer
+	db       *gorm.DB
+}
+
+func NewRelay(producer *kafka.Producer, db *gorm.DB) *Relay {
+	return &Relay{producer: producer, db: db}
+}
+
+// Run relays outbox messages to kafka until the process exits.
+func (r *Relay) Run() {
+	defer r.producer.Close()
+
+	go func() {
+		for e := range r.producer.Events() {
+			if err, ok := e.(kafka.Error); ok {
+				fmt.Println("producer error", err)
+			}
+		}
+	}()
+
+	for {
+		sent, err := r.relay()
+		if err != nil {
+			log.Println("outbox relay failed", err)
+		}
+		if sent == 0 || err != nil {
+			time.Sleep(relayIdle)
+		}
+	}
+}
+
+// relay publishes a batch of pending outbox messages. Messages go out strictly
+// in outbox order: a failed one is rescheduled and blocks the ones after it.
+// The batch is claimed first, no transaction or row lock is held while the
+// broker acknowledges the messages.
+func (r *Relay) relay() (int, error) {
+	leaseEnd := time.Now().Add(relayLease)
+	msgs, err := Claim(r.db, relayBatchSize, relayLease)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := range msgs {
+		m := &msgs[i]
+		// once the lease ends another relay may claim the rest of the batch
+		if time.Until(leaseEnd) < deliveryTimeout {
+			return i, Release(r.db, msgs[i:])
+		}
+
+		if err := r.deliver(m); err != nil {
+			log.Println("outbox msg", m.ID, "delivery failed", err)
+			if err := MarkFailed(r.db, m, err, time.Now().Add(retryBackoff(m.Attempts+1))); err != nil {
+				return i, err
+			}
+			return i, Release(r.db, msgs[i+1:])
+		}
+		if err := MarkSent(r.db, m); err != nil {
+			return i, err
+		}
+	}
+	return len(msgs), nil
+}
+
+// deliver produces m and waits until the broker acknowledges it.
+func (r *Relay) deliver(m *Message) error {
+	deliveryCh := make(chan kafka.Event, 1)
+	var headers []kafka.Header
+	if m.Headers != "" {
+		var hs map[string]string
+		if err := json.Unmarshal([]byte(m.Headers), &hs); err != nil {
+			return fmt.Errorf("bad outbox msg headers: %w", err)
+		}
+		for k, v := range hs {
+			headers = append(headers, kafka.Header{Key: k, Value: []byte(v)})
+		}
+	}
+
+	if err := r.producer.Produce(&kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &m.Topic, Partition: kafka.PartitionAny},
+		Key:            []byte(m.Key),
+		Value:          m.Payload,
+		Headers:        headers},
+		deliveryCh,
+	); err != nil {
+		return err
+	}
+
+	select {
+	case e := <-deliveryCh:
+		ev, ok := e.(*kafka.Message)
+		if !ok {
+			return fmt.Errorf("unexpected delivery report %v", e)
+		}
+		if ev.TopicPartition.Error != nil {
+			return ev.TopicPartition.Error
+		}
+		fmt.Printf("Successfully produced record to topic %s partition [%d] @ offset %v\n",
+			*ev.TopicPartition.Topic, ev.TopicPartition.Partition, ev.TopicPartition.Offset)
+		return nil
+	case <-time.After(deliveryTimeout):
+		return errors.New("delivery report timed out")
+	}
+}
+
+func retryBackoff(attempt int) time.Duration {
+	backoff := time.Second
+	for i := 1; i < attempt && backoff < maxRetryBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxRetryBackoff {
+		return maxRetryBackoff
+	}
+	return backoff
+}
@@ -0,0 +1,98 @@
+package router
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+const (
+	DefaultWorkers   = 8
+	DefaultQueueSize = 16
+)
+
+// dispatcher runs handle on a fixed pool of workers. Messages with the same
+// ordering key always go to the same worker and are handled one by one in
+// the order they were read, while different keys are handled in parallel.
+// handle reports whether the message is processed, rerouted ones included.
+type dispatcher struct {
+	queues []chan job
+	handle func(msg *kafka.Message) bool
+	wg     sync.WaitGroup
+}
+
+type job struct {
+	msg  *kafka.Message
+	run  func(msg *kafka.Message) bool
+	done func(msg *kafka.Message)
+}
+
+func newDispatcher(workers, queueSize int, handle func(msg *kafka.Message) bool) *dispatcher {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 1 {
+		queueSize = 1
+	}
+
+	d := &dispatcher{
+		queues: make([]chan job, workers),
+		handle: handle,
+	}
+	for i := range d.queues {
+		d.queues[i] = make(chan job, queueSize)
+		d.wg.Add(1)
+		go d.work(d.queues[i])
+	}
+	return d
+}
+
+func (d *dispatcher) work(queue chan job) {
+	defer d.wg.Done()
+	for j := range queue {
+		if j.run(j.msg) && j.done != nil {
+			j.done(j.msg)
+		}
+	}
+}
+
+// Dispatch queues msg for its worker and calls done once msg is processed.
+// It blocks while that worker's queue is full, which keeps the caller from
+// reading more messages than we can handle.
+func (d *dispatcher) Dispatch(msg *kafka.Message, done func(msg *kafka.Message)) {
+	d.enqueue(job{msg: msg, run: d.handle, done: done})
+}
+
+// DispatchFunc queues run for the worker of msg instead of handle, so run is
+// ordered with the other messages of the same key.
+func (d *dispatcher) DispatchFunc(msg *kafka.Message, run func(msg *kafka.Message)) {
+	d.enqueue(job{msg: msg, run: func(msg *kafka.Message) bool {
+		run(msg)
+		return true
+	}})
+}
+
+func (d *dispatcher) enqueue(j job) {
+	h := fnv.New32a()
+	h.Write(orderingKey(j.msg))
+	d.queues[h.Sum32()%uint32(len(d.queues))] <- j
+}
+
+// Close waits for all queued messages to be handled.
+func (d *dispatcher) Close() {
+	for _, q := range d.queues {
+		close(q)
+	}
+	d.wg.Wait()
+}
+
+// orderingKey is the message key, which producers set to the entity id.
+// Unkeyed messages are ordered per partition.
+func orderingKey(msg *kafka.Message) []byte {
+	if len(msg.Key) > 0 {
+		return msg.Key
+	}
+	return []byte(fmt.Sprintf("%s/%d", *msg.TopicPartition.Topic, msg.TopicPartition.Partition))
+}
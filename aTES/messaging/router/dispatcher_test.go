@@ -0,0 +1,76 @@
+package router
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func TestDispatcherKeepsKeyOrder(t *testing.T) {
+	var mu sync.Mutex
+	handled := map[string][]int{}
+	d := newDispatcher(4, 2, func(msg *kafka.Message) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		var n int
+		fmt.Sscan(string(msg.Value), &n)
+		handled[string(msg.Key)] = append(handled[string(msg.Key)], n)
+		return true
+	})
+
+	keys := []string{"task-1", "task-2", "task-3", "account-1", "account-2"}
+	for n := 0; n < 50; n++ {
+		msg := testMessage("tasks", 0, kafka.Offset(n))
+		msg.Key = []byte(keys[n%len(keys)])
+		msg.Value = []byte(fmt.Sprint(n))
+		d.Dispatch(msg, nil)
+	}
+	d.Close()
+
+	for _, key := range keys {
+		got := handled[key]
+		if len(got) != 10 {
+			t.Errorf("%s: handled %d messages, want 10", key, len(got))
+		}
+		for i := 1; i < len(got); i++ {
+			if got[i] < got[i-1] {
+				t.Errorf("%s: handled out of order %v", key, got)
+				break
+			}
+		}
+	}
+}
+
+func TestDispatcherDone(t *testing.T) {
+	d := newDispatcher(1, 1, func(msg *kafka.Message) bool {
+		return string(msg.Value) == "ok"
+	})
+
+	var done []string
+	for _, value := range []string{"ok", "lost", "ok"} {
+		msg := testMessage("tasks", 0, 0)
+		msg.Value = []byte(value)
+		d.Dispatch(msg, func(msg *kafka.Message) {
+			done = append(done, string(msg.Value))
+		})
+	}
+	d.Close()
+
+	// a message neither processed nor rerouted must not be marked done
+	if len(done) != 2 {
+		t.Errorf("done %v, want the 2 processed messages", done)
+	}
+}
+
+func TestOrderingKey(t *testing.T) {
+	keyed := testMessage("tasks", 2, 0)
+	keyed.Key = []byte("task-1")
+	if got := string(orderingKey(keyed)); got != "task-1" {
+		t.Errorf("keyed message ordered by %q, want its key", got)
+	}
+	if got := string(orderingKey(testMessage("tasks", 2, 0))); got != "tasks/2" {
+		t.Errorf("unkeyed message ordered by %q, want its partition", got)
+	}
+}
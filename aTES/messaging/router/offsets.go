@@ -0,0 +1,89 @@
+package router
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// offsets stores the offsets of the messages a consumer reads once they are
+// processed. Workers finish in any order, so the offset stored for a
+// partition is its low-water mark: it never passes a message of the
+// partition that is still in flight. The consumer must be configured with
+// enable.auto.offset.store off.
+type offsets struct {
+	mu       sync.Mutex
+	inFlight map[partition][]*pending
+	store    func(next kafka.TopicPartition) error
+}
+
+type partition struct {
+	topic string
+	id    int32
+}
+
+type pending struct {
+	offset kafka.Offset
+	done   bool
+}
+
+func newOffsets(c *kafka.Consumer) *offsets {
+	return &offsets{
+		inFlight: map[partition][]*pending{},
+		store: func(next kafka.TopicPartition) error {
+			_, err := c.StoreOffsets([]kafka.TopicPartition{next})
+			return err
+		},
+	}
+}
+
+func partitionOf(msg *kafka.Message) partition {
+	return partition{topic: *msg.TopicPartition.Topic, id: msg.TopicPartition.Partition}
+}
+
+// read tracks msg until it is done, it must be called in the order messages
+// are read.
+func (o *offsets) read(msg *kafka.Message) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	p := partitionOf(msg)
+	o.inFlight[p] = append(o.inFlight[p], &pending{offset: msg.TopicPartition.Offset})
+}
+
+// done marks msg processed and stores the offset after the messages of its
+// partition that are all done.
+func (o *offsets) done(msg *kafka.Message) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	p := partitionOf(msg)
+	queue := o.inFlight[p]
+	// a partition read again after a rewind may hold an offset twice
+	for _, m := range queue {
+		if m.offset == msg.TopicPartition.Offset && !m.done {
+			m.done = true
+			break
+		}
+	}
+
+	n := 0
+	for n < len(queue) && queue[n].done {
+		n++
+	}
+	if n == 0 {
+		return
+	}
+	next := queue[n-1].offset + 1
+	if n == len(queue) {
+		delete(o.inFlight, p)
+	} else {
+		o.inFlight[p] = queue[n:]
+	}
+
+	tp := kafka.TopicPartition{Topic: msg.TopicPartition.Topic, Partition: p.id, Offset: next}
+	if err := o.store(tp); err != nil {
+		fmt.Println("failed to store offset", tp, err)
+	}
+}
@@ -0,0 +1,75 @@
+package router
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func testMessage(topic string, partition int32, offset kafka.Offset) *kafka.Message {
+	return &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition, Offset: offset}}
+}
+
+func TestOffsets(t *testing.T) {
+	tests := []struct {
+		name string
+		read []kafka.Offset
+		done []kafka.Offset
+		want []kafka.Offset
+	}{
+		{name: "in order", read: []kafka.Offset{1, 2, 3}, done: []kafka.Offset{1, 2, 3}, want: []kafka.Offset{2, 3, 4}},
+		{name: "later message first", read: []kafka.Offset{1, 2, 3}, done: []kafka.Offset{3, 2, 1}, want: []kafka.Offset{4}},
+		{name: "gap in flight", read: []kafka.Offset{1, 2, 3}, done: []kafka.Offset{1, 3}, want: []kafka.Offset{2}},
+		{name: "nothing done", read: []kafka.Offset{1, 2}},
+		// a rewound retry partition is read from the message it was paused at
+		{name: "read again after rewind", read: []kafka.Offset{5, 6, 6}, done: []kafka.Offset{6, 5, 6}, want: []kafka.Offset{7, 7}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stored []kafka.Offset
+			o := &offsets{
+				inFlight: map[partition][]*pending{},
+				store: func(next kafka.TopicPartition) error {
+					stored = append(stored, next.Offset)
+					return nil
+				},
+			}
+			for _, offset := range tt.read {
+				o.read(testMessage("tasks", 0, offset))
+			}
+			for _, offset := range tt.done {
+				o.done(testMessage("tasks", 0, offset))
+			}
+			if !reflect.DeepEqual(stored, tt.want) {
+				t.Errorf("stored %v, want %v", stored, tt.want)
+			}
+		})
+	}
+}
+
+func TestOffsetsPerPartition(t *testing.T) {
+	stored := map[partition]kafka.Offset{}
+	o := &offsets{
+		inFlight: map[partition][]*pending{},
+		store: func(next kafka.TopicPartition) error {
+			stored[partition{topic: *next.Topic, id: next.Partition}] = next.Offset
+			return nil
+		},
+	}
+
+	o.read(testMessage("tasks", 0, 10))
+	o.read(testMessage("tasks", 1, 10))
+	o.read(testMessage("accounts", 0, 3))
+	o.done(testMessage("tasks", 1, 10))
+	o.done(testMessage("accounts", 0, 3))
+
+	want := map[partition]kafka.Offset{
+		{topic: "tasks", id: 1}:    11,
+		{topic: "accounts", id: 0}: 4,
+	}
+	if !reflect.DeepEqual(stored, want) {
+		t.Errorf("stored %v, want %v", stored, want)
+	}
+}
@@ -0,0 +1,78 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"messaging/deadletter"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// Redrive processes a dead-lettered event again, with payload replacing the
+// original one, through the same path as freshly consumed events. It runs on
+// the worker of the event key, in order with the live events of that key,
+// and waits for the result.
+func (r *Router) Redrive(id string, payload []byte) error {
+	dl, err := deadletter.Get(r.db, id)
+	if err != nil {
+		return err
+	}
+	if dl.Status == deadletter.Status_Redriven {
+		return fmt.Errorf("dead letter %s is already redriven", id)
+	}
+
+	handler, ok := r.handlers[dl.Topic]
+	if !ok {
+		return fmt.Errorf("no handler for topic %s", dl.Topic)
+	}
+
+	var headers map[string]string
+	if err := json.Unmarshal([]byte(dl.Headers), &headers); err != nil {
+		return fmt.Errorf("bad dead letter headers: %w", err)
+	}
+	msg := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &dl.Topic, Partition: kafka.PartitionAny},
+		Key:            []byte(dl.Key),
+		Value:          payload,
+	}
+	for k, v := range headers {
+		if !strings.HasPrefix(k, "x-") {
+			msg.Headers = append(msg.Headers, kafka.Header{Key: k, Value: []byte(v)})
+		}
+	}
+
+	done := make(chan error, 1)
+	r.dispatcher.DispatchFunc(msg, func(msg *kafka.Message) {
+		done <- r.redrive(id, handler, msg)
+	})
+	return <-done
+}
+
+// redrive runs on the worker, the dead letter is reloaded in case a
+// concurrent redrive of it went first.
+func (r *Router) redrive(id string, handler Handler, msg *kafka.Message) error {
+	dl, err := deadletter.Get(r.db, id)
+	if err != nil {
+		return err
+	}
+	if dl.Status == deadletter.Status_Redriven {
+		return fmt.Errorf("dead letter %s is already redriven", id)
+	}
+
+	dl.Payload = string(msg.Value)
+	dl.Attempts++
+	if err, stack := safeHandle(handler, msg); err != nil {
+		dl.Error, dl.Stack = err.Error(), string(stack)
+		if err := deadletter.Save(r.db, dl); err != nil {
+			fmt.Println("failed to save dead letter", err)
+		}
+		return err
+	}
+
+	now := time.Now()
+	dl.Status = deadletter.Status_Redriven
+	dl.RedrivenAt = &now
+	return deadletter.Save(r.db, dl)
+}
@@ -0,0 +1,79 @@
+package router
+
+import (
+	"errors"
+	"messaging/deadletter"
+	"messaging/internal/dbtest"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func TestRedrive(t *testing.T) {
+	var handled []*kafka.Message
+	fixed := false
+	r := &Router{
+		topics: testTopics,
+		handlers: map[string]Handler{"tasks-stream": func(msg *kafka.Message) error {
+			handled = append(handled, msg)
+			if !fixed {
+				return errors.New("bad price")
+			}
+			return nil
+		}},
+		maxAttempts: 1,
+		db:          dbtest.Open(t, &deadletter.DeadLetter{}),
+	}
+	r.dispatcher = newDispatcher(1, 1, r.process)
+	defer r.dispatcher.Close()
+
+	msg := testMessage("tasks-stream", 0, 3)
+	msg.Key = []byte("task-1")
+	msg.Value = []byte(`{"price":-1}`)
+	msg.Headers = []kafka.Header{{Key: "event_version", Value: []byte("2")}}
+	cause := errors.New("bad price")
+	out, attempt := r.reroute(msg, "tasks-stream", cause, []byte("stack"))
+	r.storeDeadLetter(out, "tasks-stream", cause, []byte("stack"), attempt)
+
+	failed, err := deadletter.GetFailed(r.db)
+	if err != nil || len(failed) != 1 {
+		t.Fatalf("got %d dead letters, %v, want 1", len(failed), err)
+	}
+	id := failed[0].PublicID.String()
+
+	if err := r.Redrive(id, []byte(`{"price":-2}`)); err == nil {
+		t.Fatal("failing redrive reported no error")
+	}
+	dl, err := deadletter.Get(r.db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dl.Status != deadletter.Status_Failed || dl.Attempts != 2 || dl.Payload != `{"price":-2}` {
+		t.Errorf("after a failed redrive got %s, %d attempts, payload %s", dl.Status, dl.Attempts, dl.Payload)
+	}
+
+	fixed = true
+	if err := r.Redrive(id, []byte(`{"price":10}`)); err != nil {
+		t.Fatal(err)
+	}
+	dl, err = deadletter.Get(r.db, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dl.Status != deadletter.Status_Redriven || dl.RedrivenAt == nil {
+		t.Errorf("dead letter is %s, want redriven", dl.Status)
+	}
+
+	last := handled[len(handled)-1]
+	if string(last.Key) != "task-1" || string(last.Value) != `{"price":10}` {
+		t.Errorf("handled %s: %s, want the fixed payload of task-1", last.Key, last.Value)
+	}
+	// routing headers of the failure are not passed to the handler
+	if len(last.Headers) != 1 || Header(last, "event_version") != "2" {
+		t.Errorf("handler got headers %v", last.Headers)
+	}
+
+	if err := r.Redrive(id, []byte(`{"price":10}`)); err == nil {
+		t.Error("dead letter redriven twice")
+	}
+}
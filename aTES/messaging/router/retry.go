@@ -0,0 +1,237 @@
+package router
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"messaging/deadletter"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+const (
+	DefaultMaxAttempts = 5
+
+	retryBaseBackoff = time.Second
+	deliveryTimeout  = time.Second * 30
+	// retryPollInterval is the longest the retry consumer waits for a message
+	// before it checks whether a paused partition is due.
+	retryPollInterval = time.Second * 10
+
+	HeaderOriginalTopic     = "x-original-topic"
+	HeaderOriginalPartition = "x-original-partition"
+	HeaderOriginalOffset    = "x-original-offset"
+	HeaderAttempt           = "x-attempt"
+	HeaderNotBefore         = "x-not-before"
+	HeaderError             = "x-error"
+	HeaderStack             = "x-stack"
+	HeaderFailedAt          = "x-failed-at"
+)
+
+// ErrInvalidEvent marks events that can never be applied, such as the ones
+// that don't match their schema. They are quarantined at once, as retrying
+// them can't help.
+var ErrInvalidEvent = errors.New("invalid event")
+
+// RunRetries feeds the retry topic to the dispatcher once each message is
+// due. The partition of a message that is not due yet is paused and rewound
+// to it, so neither the workers nor the other topics wait for the backoff.
+// Offsets are stored once dispatched messages are processed, a rewound one
+// is read again after a restart.
+func (r *Router) RunRetries() {
+	c, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers":        "broker:29092",
+		"broker.address.family":    "v4",
+		"group.id":                 r.topics.Retry,
+		"session.timeout.ms":       6000,
+		"auto.offset.reset":        "earliest",
+		"enable.auto.offset.store": false,
+	})
+	if err != nil {
+		fmt.Println("retry consumer failed", err)
+		os.Exit(1)
+	}
+	defer c.Close()
+
+	topic := r.topics.Retry
+	if err := c.SubscribeTopics([]string{topic}, nil); err != nil {
+		fmt.Println(err)
+	}
+
+	offsets := newOffsets(c)
+	paused := map[int32]time.Time{}
+	for {
+		wait := retryPollInterval
+		for partition, due := range paused {
+			if left := time.Until(due); left > 0 {
+				if left < wait {
+					wait = left
+				}
+				continue
+			}
+			tp := kafka.TopicPartition{Topic: &topic, Partition: partition}
+			if err := c.Resume([]kafka.TopicPartition{tp}); err != nil {
+				fmt.Println("failed to resume retry partition", partition, err)
+			}
+			delete(paused, partition)
+		}
+
+		msg, err := c.ReadMessage(wait)
+		if err != nil {
+			continue
+		}
+
+		notBefore, err := time.Parse(time.RFC3339Nano, Header(msg, HeaderNotBefore))
+		if err == nil && time.Now().Before(notBefore) {
+			tp := msg.TopicPartition
+			if err := c.Pause([]kafka.TopicPartition{tp}); err != nil {
+				fmt.Println("failed to pause retry partition", tp.Partition, err)
+			}
+			if err := c.Seek(tp, 0); err != nil {
+				fmt.Println("failed to rewind retry partition", tp.Partition, err)
+			}
+			paused[tp.Partition] = notBefore
+			continue
+		}
+
+		offsets.read(msg)
+		r.dispatcher.Dispatch(msg, offsets.done)
+	}
+}
+
+// safeHandle turns a panic in the handler into an error with its stack.
+func safeHandle(handler Handler, msg *kafka.Message) (err error, stack []byte) {
+	defer func() {
+		if r := recover(); r != nil {
+			err, stack = fmt.Errorf("panic: %v", r), debug.Stack()
+		}
+	}()
+
+	if err := handler(msg); err != nil {
+		return err, debug.Stack()
+	}
+	return nil, nil
+}
+
+// fail schedules the next attempt with exponential backoff or, once
+// MaxAttempts is reached, moves the event to the dead-letter topic. Invalid
+// events go to the quarantine topic right away. Both are kept for the admin
+// dead-letter page, where they can be fixed and redriven.
+func (r *Router) fail(msg *kafka.Message, originalTopic string, cause error, stack []byte) error {
+	out, attempt := r.reroute(msg, originalTopic, cause, stack)
+	if *out.TopicPartition.Topic != r.topics.Retry {
+		r.storeDeadLetter(out, originalTopic, cause, stack, attempt)
+	}
+	return r.republish(out)
+}
+
+// reroute builds the message fail sends for msg and returns it with the
+// number of the attempt that failed.
+func (r *Router) reroute(msg *kafka.Message, originalTopic string, cause error, stack []byte) (*kafka.Message, int) {
+	attempt, _ := strconv.Atoi(Header(msg, HeaderAttempt))
+	attempt++
+
+	headers := []kafka.Header{}
+	for _, h := range msg.Headers {
+		if !strings.HasPrefix(h.Key, "x-") {
+			headers = append(headers, h)
+		}
+	}
+	headers = append(headers,
+		kafka.Header{Key: HeaderOriginalTopic, Value: []byte(originalTopic)},
+		kafka.Header{Key: HeaderAttempt, Value: []byte(strconv.Itoa(attempt))},
+		kafka.Header{Key: HeaderError, Value: []byte(cause.Error())},
+	)
+	if *msg.TopicPartition.Topic == originalTopic {
+		headers = append(headers,
+			kafka.Header{Key: HeaderOriginalPartition, Value: []byte(strconv.Itoa(int(msg.TopicPartition.Partition)))},
+			kafka.Header{Key: HeaderOriginalOffset, Value: []byte(msg.TopicPartition.Offset.String())},
+		)
+	} else {
+		for _, key := range []string{HeaderOriginalPartition, HeaderOriginalOffset} {
+			headers = append(headers, kafka.Header{Key: key, Value: []byte(Header(msg, key))})
+		}
+	}
+
+	topic := r.topics.Retry
+	if quarantine := errors.Is(cause, ErrInvalidEvent); quarantine || attempt >= r.maxAttempts {
+		topic = r.topics.DeadLetter
+		if quarantine {
+			topic = r.topics.Quarantine
+		}
+		headers = append(headers,
+			kafka.Header{Key: HeaderStack, Value: stack},
+			kafka.Header{Key: HeaderFailedAt, Value: []byte(time.Now().UTC().Format(time.RFC3339Nano))},
+		)
+	} else {
+		notBefore := time.Now().Add(retryBaseBackoff << (attempt - 1))
+		headers = append(headers,
+			kafka.Header{Key: HeaderNotBefore, Value: []byte(notBefore.UTC().Format(time.RFC3339Nano))},
+		)
+	}
+
+	out := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            msg.Key,
+		Value:          msg.Value,
+		Headers:        headers,
+	}
+	return out, attempt
+}
+
+// storeDeadLetter keeps the failed event for the admin dead-letter page.
+func (r *Router) storeDeadLetter(msg *kafka.Message, originalTopic string, cause error, stack []byte, attempts int) {
+	headers := map[string]string{}
+	for _, h := range msg.Headers {
+		if h.Key != HeaderStack {
+			headers[h.Key] = string(h.Value)
+		}
+	}
+	encoded, _ := json.Marshal(headers)
+
+	if err := deadletter.Create(r.db, &deadletter.DeadLetter{
+		Topic:    originalTopic,
+		Key:      string(msg.Key),
+		Payload:  string(msg.Value),
+		Headers:  string(encoded),
+		Error:    cause.Error(),
+		Stack:    string(stack),
+		Attempts: attempts,
+	}); err != nil {
+		fmt.Println("failed to store dead letter", err)
+	}
+}
+
+// republish produces msg and waits until the broker acknowledges it.
+func (r *Router) republish(msg *kafka.Message) error {
+	deliveryCh := make(chan kafka.Event, 1)
+	if err := r.rerouter.Produce(msg, deliveryCh); err != nil {
+		return err
+	}
+
+	select {
+	case e := <-deliveryCh:
+		ev, ok := e.(*kafka.Message)
+		if !ok {
+			return fmt.Errorf("unexpected delivery report %v", e)
+		}
+		return ev.TopicPartition.Error
+	case <-time.After(deliveryTimeout):
+		return errors.New("delivery report timed out")
+	}
+}
+
+// Header returns the value of the msg header key, empty if there is none.
+func Header(msg *kafka.Message, key string) string {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
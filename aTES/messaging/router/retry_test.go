@@ -0,0 +1,104 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+var testTopics = Topics{Retry: "svc-retry", DeadLetter: "svc-dlq", Quarantine: "svc-quarantine"}
+
+func TestReroute(t *testing.T) {
+	r := &Router{topics: testTopics, maxAttempts: 3}
+
+	tests := []struct {
+		name        string
+		attempt     string
+		cause       error
+		wantTopic   string
+		wantAttempt int
+	}{
+		{name: "first failure", cause: errors.New("db down"), wantTopic: "svc-retry", wantAttempt: 1},
+		{name: "retry fails", attempt: "1", cause: errors.New("db down"), wantTopic: "svc-retry", wantAttempt: 2},
+		{name: "last attempt fails", attempt: "2", cause: errors.New("db down"), wantTopic: "svc-dlq", wantAttempt: 3},
+		{name: "invalid event", cause: fmt.Errorf("bad data: %w", ErrInvalidEvent), wantTopic: "svc-quarantine", wantAttempt: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := testMessage("tasks-stream", 2, 40)
+			msg.Key = []byte("task-1")
+			if tt.attempt != "" {
+				msg.Headers = []kafka.Header{{Key: HeaderAttempt, Value: []byte(tt.attempt)}}
+			}
+
+			out, attempt := r.reroute(msg, "tasks-stream", tt.cause, []byte("stack"))
+			if got := *out.TopicPartition.Topic; got != tt.wantTopic {
+				t.Errorf("topic = %s, want %s", got, tt.wantTopic)
+			}
+			if attempt != tt.wantAttempt || Header(out, HeaderAttempt) != fmt.Sprint(tt.wantAttempt) {
+				t.Errorf("attempt = %d, header %s, want %d", attempt, Header(out, HeaderAttempt), tt.wantAttempt)
+			}
+			if string(out.Key) != "task-1" || Header(out, HeaderError) != tt.cause.Error() {
+				t.Errorf("got key %s, error %q", out.Key, Header(out, HeaderError))
+			}
+
+			retry := tt.wantTopic == testTopics.Retry
+			if got := Header(out, HeaderNotBefore) != ""; got != retry {
+				t.Errorf("has %s = %v, want %v", HeaderNotBefore, got, retry)
+			}
+			if got := Header(out, HeaderStack) != ""; got == retry {
+				t.Errorf("has %s = %v, want %v", HeaderStack, got, !retry)
+			}
+		})
+	}
+}
+
+func TestRerouteBackoff(t *testing.T) {
+	r := &Router{topics: testTopics, maxAttempts: 5}
+	msg := testMessage("svc-retry", 0, 7)
+	msg.Headers = []kafka.Header{{Key: HeaderAttempt, Value: []byte("2")}}
+
+	before := time.Now()
+	out, _ := r.reroute(msg, "tasks-stream", errors.New("db down"), nil)
+	notBefore, err := time.Parse(time.RFC3339Nano, Header(out, HeaderNotBefore))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the third attempt waits 4 base backoffs
+	if wait := notBefore.Sub(before); wait < 4*retryBaseBackoff || wait > 5*retryBaseBackoff {
+		t.Errorf("waits %s before the third attempt", wait)
+	}
+}
+
+func TestRerouteHeaders(t *testing.T) {
+	r := &Router{topics: testTopics, maxAttempts: 5}
+
+	original := testMessage("tasks-stream", 2, 40)
+	original.Headers = []kafka.Header{{Key: "event_version", Value: []byte("2")}}
+	retried, _ := r.reroute(original, "tasks-stream", errors.New("db down"), nil)
+
+	// the retried copy comes back from the retry topic and fails again
+	retried.TopicPartition = kafka.TopicPartition{Topic: &testTopics.Retry, Partition: 0, Offset: 7}
+	out, _ := r.reroute(retried, "tasks-stream", errors.New("still down"), nil)
+
+	counts := map[string]int{}
+	for _, h := range out.Headers {
+		counts[h.Key]++
+	}
+	for _, key := range []string{"event_version", HeaderOriginalTopic, HeaderAttempt, HeaderError, HeaderNotBefore, HeaderOriginalPartition, HeaderOriginalOffset} {
+		if counts[key] != 1 {
+			t.Errorf("%s header %d times, want once", key, counts[key])
+		}
+	}
+	// the position is the one in the original topic, not in the retry topic
+	if Header(out, HeaderOriginalPartition) != "2" || Header(out, HeaderOriginalOffset) != "40" {
+		t.Errorf("original position %s/%s, want 2/40", Header(out, HeaderOriginalPartition), Header(out, HeaderOriginalOffset))
+	}
+	if Header(out, HeaderError) != "still down" {
+		t.Errorf("error = %q, want the last one", Header(out, HeaderError))
+	}
+}
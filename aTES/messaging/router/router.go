@@ -0,0 +1,155 @@
+// Package router runs the handlers of the events a service consumes, keeping
+// the order of every key, and retries or dead-letters the ones that fail.
+package router
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"gorm.io/gorm"
+)
+
+// Handler applies a message consumed from the topic it is registered for.
+type Handler func(msg *kafka.Message) error
+
+// Topics are where a service reroutes the events it failed to process.
+type Topics struct {
+	// Retry holds events waiting for another processing attempt and
+	// DeadLetter the ones that failed every attempt. Quarantine holds events
+	// that don't match their schema, they are never applied.
+	Retry      string
+	DeadLetter string
+	Quarantine string
+}
+
+// Config sizes the worker pool and sets how many times a failing event is
+// processed.
+type Config struct {
+	// Workers is the number of events handled in parallel and QueueSize is
+	// how many events may wait for each worker before reading is paused.
+	Workers   int
+	QueueSize int
+	// MaxAttempts is how many times a failing event is processed before it
+	// is moved to the dead-letter topic.
+	MaxAttempts int
+}
+
+// DefaultConfig is used for the settings the environment doesn't set.
+var DefaultConfig = Config{
+	Workers:     DefaultWorkers,
+	QueueSize:   DefaultQueueSize,
+	MaxAttempts: DefaultMaxAttempts,
+}
+
+// ConfigFromEnv reads the config from CONSUMER_WORKERS, CONSUMER_QUEUE_SIZE
+// and CONSUMER_MAX_ATTEMPTS, DefaultConfig is used for the ones not set.
+func ConfigFromEnv() Config {
+	cfg := DefaultConfig
+	cfg.Workers = envInt("CONSUMER_WORKERS", cfg.Workers)
+	cfg.QueueSize = envInt("CONSUMER_QUEUE_SIZE", cfg.QueueSize)
+	cfg.MaxAttempts = envInt("CONSUMER_MAX_ATTEMPTS", cfg.MaxAttempts)
+	return cfg
+}
+
+func envInt(name string, def int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		panic(fmt.Errorf("bad %s: %q", name, v))
+	}
+	return n
+}
+
+// Router runs the handlers of consumed events on the dispatcher and reroutes
+// the events that fail to the retry, dead-letter or quarantine topic.
+type Router struct {
+	topics      Topics
+	handlers    map[string]Handler
+	maxAttempts int
+	dispatcher  *dispatcher
+	rerouter    *kafka.Producer
+	db          *gorm.DB
+}
+
+// New makes a router for the handlers, keyed by topic. Dead letters are kept
+// in db.
+func New(db *gorm.DB, topics Topics, handlers map[string]Handler, cfg Config) *Router {
+	rerouter, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers": "broker:29092",
+		"acks":              "all",
+	})
+	if err != nil {
+		fmt.Println("rerouter failed", err)
+		os.Exit(1)
+	}
+
+	r := &Router{
+		topics:      topics,
+		handlers:    handlers,
+		maxAttempts: cfg.MaxAttempts,
+		rerouter:    rerouter,
+		db:          db,
+	}
+	// events are keyed by entity id, the dispatcher keeps their order
+	r.dispatcher = newDispatcher(cfg.Workers, cfg.QueueSize, r.process)
+	return r
+}
+
+// Consume reads the topics c is subscribed to and queues every message for
+// the worker of its key. The offset of a message is stored once it is
+// processed or rerouted, so c must have enable.auto.offset.store off.
+func (r *Router) Consume(c *kafka.Consumer) {
+	offsets := newOffsets(c)
+	for {
+		msg, err := c.ReadMessage(time.Second * 10)
+		if err != nil {
+			continue
+		}
+		fmt.Printf("Consumed event from topic %s: key = %-10s value = %s\n",
+			*msg.TopicPartition.Topic, string(msg.Key), string(msg.Value))
+
+		offsets.read(msg)
+		r.dispatcher.Dispatch(msg, offsets.done)
+	}
+}
+
+// Close waits for the queued events and closes the rerouter.
+func (r *Router) Close() {
+	r.dispatcher.Close()
+	r.rerouter.Close()
+}
+
+// process runs the handler for msg, which is either an original event or
+// one coming back from the retry topic, and sends it on to retry or to the
+// dead-letter topic when it fails. It reports false only if msg could be
+// neither processed nor rerouted, its offset must not be stored then.
+func (r *Router) process(msg *kafka.Message) bool {
+	topic := *msg.TopicPartition.Topic
+	if topic == r.topics.Retry {
+		topic = Header(msg, HeaderOriginalTopic)
+	}
+
+	handler, ok := r.handlers[topic]
+	if !ok {
+		fmt.Println("no handler for topic", topic)
+		return true
+	}
+
+	err, stack := safeHandle(handler, msg)
+	if err == nil {
+		return true
+	}
+	fmt.Println("failed to process event from", topic, err)
+
+	if err := r.fail(msg, topic, err, stack); err != nil {
+		fmt.Println("failed to reroute event, it is read again after a restart", err, string(msg.Value))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,28 @@
+package router
+
+import "testing"
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("CONSUMER_WORKERS", "3")
+	t.Setenv("CONSUMER_QUEUE_SIZE", "")
+	t.Setenv("CONSUMER_MAX_ATTEMPTS", "7")
+
+	want := Config{Workers: 3, QueueSize: DefaultQueueSize, MaxAttempts: 7}
+	if got := ConfigFromEnv(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestConfigFromEnvRejectsBadValues(t *testing.T) {
+	for _, v := range []string{"0", "-1", "many"} {
+		t.Run(v, func(t *testing.T) {
+			t.Setenv("CONSUMER_WORKERS", v)
+			defer func() {
+				if recover() == nil {
+					t.Errorf("CONSUMER_WORKERS=%s accepted", v)
+				}
+			}()
+			ConfigFromEnv()
+		})
+	}
+}
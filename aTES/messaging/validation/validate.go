@@ -0,0 +1,29 @@
+// Package validation checks consumed events against their schema.
+package validation
+
+import (
+	"events"
+	"fmt"
+	"messaging/router"
+)
+
+// Validator checks raw data against a schema of the registry, such as
+// eventschemaregistry.Validator.
+type Validator interface {
+	Validate(data []byte, name string, version int) error
+}
+
+// Validate checks the raw event against the schema of its name and version.
+// Events without a schema are left to the processors, which ignore them.
+// Events that don't match are router.ErrInvalidEvent, they are quarantined
+// at once, as retrying them can't help.
+func Validate(v Validator, evt *events.Event, raw []byte) error {
+	schema, ok := events.SchemaByEvt[evt.EventName]
+	if !ok {
+		return nil
+	}
+	if err := v.Validate(raw, schema, int(evt.EventVersion)); err != nil {
+		return fmt.Errorf("%w: %s v%d: %s", router.ErrInvalidEvent, evt.EventName, evt.EventVersion, err)
+	}
+	return nil
+}
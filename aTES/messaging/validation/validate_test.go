@@ -0,0 +1,41 @@
+package validation
+
+import (
+	"errors"
+	"events"
+	"messaging/router"
+	"testing"
+)
+
+type validatorFunc func(data []byte, name string, version int) error
+
+func (f validatorFunc) Validate(data []byte, name string, version int) error {
+	return f(data, name, version)
+}
+
+func TestValidate(t *testing.T) {
+	var checked []string
+	v := validatorFunc(func(data []byte, name string, version int) error {
+		checked = append(checked, name)
+		if string(data) == "bad" {
+			return errors.New("title is required")
+		}
+		return nil
+	})
+
+	evt := &events.Event{EventName: events.TaskCreatedEvt, EventVersion: 2}
+	if err := Validate(v, evt, []byte("good")); err != nil {
+		t.Errorf("valid event: %s", err)
+	}
+	if err := Validate(v, evt, []byte("bad")); !errors.Is(err, router.ErrInvalidEvent) {
+		t.Errorf("invalid event: got %v, want ErrInvalidEvent", err)
+	}
+	if len(checked) != 2 || checked[0] != events.SchemaByEvt[events.TaskCreatedEvt] {
+		t.Errorf("checked schemas %v, want %s twice", checked, events.SchemaByEvt[events.TaskCreatedEvt])
+	}
+
+	unknown := &events.Event{EventName: "test.unknown", EventVersion: 1}
+	if err := Validate(v, unknown, []byte("bad")); err != nil {
+		t.Errorf("event without a schema: %s", err)
+	}
+}
@@ -0,0 +1,56 @@
+package versioning
+
+import (
+	"events"
+	"messaging/router"
+	"strconv"
+	"strings"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// Versions are the schema versions each event is published in. During a
+// schema migration an event lists both the old and the new version, and a
+// copy is published per version until every consumer is upgraded.
+type Versions map[string][]int
+
+// Headers tell consumers which version the copy of evtName is in and which
+// other copies are published, see IsPreferredCopy.
+func (vs Versions) Headers(evtName string, version int) map[string]string {
+	all := make([]string, 0, len(vs[evtName]))
+	for _, v := range vs[evtName] {
+		all = append(all, strconv.Itoa(v))
+	}
+	return map[string]string{
+		events.HeaderEventName:     evtName,
+		events.HeaderEventVersion:  strconv.Itoa(version),
+		events.HeaderEventVersions: strings.Join(all, ","),
+	}
+}
+
+// IsPreferredCopy reports whether msg is the copy of a dual-published event
+// to handle: the one in the newest version the processors understand. Other
+// copies are skipped, events published in a single version are always handled.
+func IsPreferredCopy(msg *kafka.Message, evtName string) bool {
+	published := strings.Split(router.Header(msg, events.HeaderEventVersions), ",")
+	if len(published) < 2 {
+		return true
+	}
+	version, err := strconv.ParseInt(router.Header(msg, events.HeaderEventVersion), 10, 64)
+	if err != nil {
+		return true
+	}
+
+	latest, preferred := Latest(evtName), int64(0)
+	for _, p := range published {
+		v, err := strconv.ParseInt(strings.TrimSpace(p), 10, 64)
+		if err == nil && v <= latest && v > preferred {
+			preferred = v
+		}
+	}
+	if preferred == 0 {
+		// none of the versions is known, let Upcast report it
+		return true
+	}
+	return version == preferred
+}
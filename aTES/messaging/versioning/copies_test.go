@@ -0,0 +1,54 @@
+package versioning
+
+import (
+	"events"
+	"reflect"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func TestHeaders(t *testing.T) {
+	vs := Versions{"test.dual": {1, 2}}
+	want := map[string]string{
+		events.HeaderEventName:     "test.dual",
+		events.HeaderEventVersion:  "2",
+		events.HeaderEventVersions: "1,2",
+	}
+	if got := vs.Headers("test.dual", 2); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestIsPreferredCopy(t *testing.T) {
+	// test.renamed is known up to version 3, see upcast_test.go
+	tests := []struct {
+		name      string
+		version   string
+		published string
+		want      bool
+	}{
+		{name: "single version", version: "1", published: "1", want: true},
+		{name: "no headers", want: true},
+		{name: "newest known copy", version: "3", published: "2,3", want: true},
+		{name: "older copy", version: "2", published: "2,3", want: false},
+		{name: "known copy of a newer rollout", version: "3", published: "3,4", want: true},
+		{name: "unknown copy", version: "4", published: "3,4", want: false},
+		{name: "no known copy", version: "5", published: "4,5", want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &kafka.Message{}
+			if tt.published != "" {
+				msg.Headers = []kafka.Header{
+					{Key: events.HeaderEventVersion, Value: []byte(tt.version)},
+					{Key: events.HeaderEventVersions, Value: []byte(tt.published)},
+				}
+			}
+			if got := IsPreferredCopy(msg, "test.renamed"); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
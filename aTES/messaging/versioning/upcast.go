@@ -0,0 +1,81 @@
+// Package versioning lets the schema of an event change while services
+// publishing and consuming it are upgraded one by one.
+package versioning
+
+import (
+	"encoding/json"
+	"events"
+	"fmt"
+)
+
+// Upcaster converts the data of an event to the next version of its schema.
+type Upcaster func(data map[string]interface{}) error
+
+type upcasterKey struct {
+	evtName string
+	version int64
+}
+
+var (
+	upcasters = map[upcasterKey]Upcaster{}
+	// latestVersions is the version processors expect for every event, events
+	// without upcasters are expected in version 1.
+	latestVersions = map[string]int64{}
+)
+
+// Register adds the conversion of evtName from version to version+1. Services
+// register the upcasters of the events they consume on init.
+func Register(evtName string, version int64, fn Upcaster) {
+	upcasters[upcasterKey{evtName, version}] = fn
+	if latestVersions[evtName] < version+1 {
+		latestVersions[evtName] = version + 1
+	}
+}
+
+// Latest is the version of evtName the processors expect.
+func Latest(evtName string) int64 {
+	if v, ok := latestVersions[evtName]; ok {
+		return v
+	}
+	return 1
+}
+
+// Upcast brings evt to the latest version known to the processors, so they
+// handle every version through the same code path.
+func Upcast(evt *events.Event) error {
+	latest := Latest(evt.EventName)
+	if evt.EventVersion > latest {
+		return fmt.Errorf("unsupported %s version %d, latest known is %d", evt.EventName, evt.EventVersion, latest)
+	}
+	if evt.EventVersion == latest {
+		return nil
+	}
+	if evt.EventVersion < 1 {
+		return fmt.Errorf("invalid %s version %d", evt.EventName, evt.EventVersion)
+	}
+
+	data := map[string]interface{}{}
+	if err := json.Unmarshal(evt.Data, &data); err != nil {
+		return fmt.Errorf("parse %s data failed: %w", evt.EventName, err)
+	}
+	if data == nil {
+		data = map[string]interface{}{}
+	}
+	for v := evt.EventVersion; v < latest; v++ {
+		up, ok := upcasters[upcasterKey{evt.EventName, v}]
+		if !ok {
+			return fmt.Errorf("no upcaster for %s version %d", evt.EventName, v)
+		}
+		if err := up(data); err != nil {
+			return fmt.Errorf("upcast %s from version %d failed: %w", evt.EventName, v, err)
+		}
+	}
+
+	raw, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	evt.Data = raw
+	evt.EventVersion = latest
+	return nil
+}
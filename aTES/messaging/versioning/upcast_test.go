@@ -0,0 +1,71 @@
+package versioning
+
+import (
+	"encoding/json"
+	"events"
+	"strings"
+	"testing"
+)
+
+func init() {
+	// test.renamed v1 has name, v2 calls it title and v3 adds a priority
+	Register("test.renamed", 1, func(data map[string]interface{}) error {
+		data["title"] = data["name"]
+		delete(data, "name")
+		return nil
+	})
+	Register("test.renamed", 2, func(data map[string]interface{}) error {
+		data["priority"] = "normal"
+		return nil
+	})
+	// test.gap has no upcaster from v1
+	Register("test.gap", 2, func(data map[string]interface{}) error { return nil })
+}
+
+func TestLatest(t *testing.T) {
+	if got := Latest("test.renamed"); got != 3 {
+		t.Errorf("latest test.renamed = %d, want 3", got)
+	}
+	if got := Latest("test.unversioned"); got != 1 {
+		t.Errorf("latest of an event without upcasters = %d, want 1", got)
+	}
+}
+
+func TestUpcast(t *testing.T) {
+	tests := []struct {
+		name     string
+		evtName  string
+		version  int64
+		data     string
+		want     string
+		wantErr  string
+		wantVers int64
+	}{
+		{name: "through every version", evtName: "test.renamed", version: 1, data: `{"name": "fix login"}`, want: `{"priority":"normal","title":"fix login"}`, wantVers: 3},
+		{name: "from a middle version", evtName: "test.renamed", version: 2, data: `{"title": "fix login"}`, want: `{"priority":"normal","title":"fix login"}`, wantVers: 3},
+		{name: "latest version untouched", evtName: "test.renamed", version: 3, data: `{"title": "fix login", "priority": "high"}`, want: `{"title": "fix login", "priority": "high"}`, wantVers: 3},
+		{name: "version from the future", evtName: "test.renamed", version: 4, data: `{}`, wantErr: "unsupported test.renamed version 4"},
+		{name: "invalid version", evtName: "test.renamed", version: 0, data: `{}`, wantErr: "invalid test.renamed version 0"},
+		{name: "missing upcaster", evtName: "test.gap", version: 1, data: `{}`, wantErr: "no upcaster for test.gap version 1"},
+		{name: "null data", evtName: "test.renamed", version: 2, data: `null`, want: `{"priority":"normal"}`, wantVers: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evt := &events.Event{EventName: tt.evtName, EventVersion: tt.version, Data: json.RawMessage(tt.data)}
+			err := Upcast(evt)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("got error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(evt.Data) != tt.want || evt.EventVersion != tt.wantVers {
+				t.Errorf("got v%d %s, want v%d %s", evt.EventVersion, evt.Data, tt.wantVers, tt.want)
+			}
+		})
+	}
+}
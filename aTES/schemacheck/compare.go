@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// comparer collects the incompatibilities between two versions of a schema.
+type comparer struct {
+	oldRoot, newRoot map[string]interface{}
+	violations       []string
+}
+
+func (c *comparer) backward(path, format string, args ...interface{}) {
+	c.violations = append(c.violations, fmt.Sprintf("backward: %s: %s", displayPath(path), fmt.Sprintf(format, args...)))
+}
+
+func (c *comparer) forward(path, format string, args ...interface{}) {
+	c.violations = append(c.violations, fmt.Sprintf("forward: %s: %s", displayPath(path), fmt.Sprintf(format, args...)))
+}
+
+func displayPath(path string) string {
+	if path == "" {
+		return "<root>"
+	}
+	return path
+}
+
+func (c *comparer) compare(path string, old, new map[string]interface{}) {
+	old, new = resolve(c.oldRoot, old), resolve(c.newRoot, new)
+	if old == nil || new == nil {
+		return
+	}
+
+	oldTypes, newTypes := typeSet(old), typeSet(new)
+	if oldTypes != nil && newTypes != nil {
+		if removed := difference(oldTypes, newTypes); len(removed) > 0 {
+			c.backward(path, "type narrowed, %s no longer allowed", strings.Join(removed, ", "))
+		}
+		if added := difference(newTypes, oldTypes); len(added) > 0 {
+			c.forward(path, "type widened, %s now allowed", strings.Join(added, ", "))
+		}
+	}
+
+	// event_version is expected to change with every version
+	if path != "event_version" {
+		oldEnum, newEnum := enumSet(old), enumSet(new)
+		switch {
+		case oldEnum != nil && newEnum != nil:
+			if removed := difference(oldEnum, newEnum); len(removed) > 0 {
+				c.backward(path, "enum values removed: %s", strings.Join(removed, ", "))
+			}
+			if added := difference(newEnum, oldEnum); len(added) > 0 {
+				c.forward(path, "enum values added: %s", strings.Join(added, ", "))
+			}
+		case oldEnum == nil && newEnum != nil:
+			c.backward(path, "enum introduced")
+		case oldEnum != nil && newEnum == nil:
+			c.forward(path, "enum dropped")
+		}
+	}
+
+	oldReq, newReq := requiredSet(old), requiredSet(new)
+	oldProps, _ := old["properties"].(map[string]interface{})
+	newProps, _ := new["properties"].(map[string]interface{})
+	for _, name := range sortedKeys(oldReq) {
+		if _, ok := newReq[name]; ok {
+			continue
+		}
+		if _, ok := newProps[name]; ok {
+			c.forward(join(path, name), "no longer required")
+		} else {
+			c.forward(join(path, name), "required field removed")
+		}
+	}
+	for _, name := range sortedKeys(newReq) {
+		if _, ok := oldReq[name]; !ok {
+			c.backward(join(path, name), "required field added")
+		}
+	}
+
+	for _, name := range sortedKeys(oldProps) {
+		newProp, ok := newProps[name].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		oldProp, _ := oldProps[name].(map[string]interface{})
+		c.compare(join(path, name), oldProp, newProp)
+	}
+
+	oldItems, _ := old["items"].(map[string]interface{})
+	newItems, _ := new["items"].(map[string]interface{})
+	if oldItems != nil && newItems != nil {
+		c.compare(path+"[]", oldItems, newItems)
+	}
+}
+
+// resolve follows local "#/..." references.
+func resolve(root, node map[string]interface{}) map[string]interface{} {
+	for i := 0; node != nil && i < 32; i++ {
+		ref, ok := node["$ref"].(string)
+		if !ok || !strings.HasPrefix(ref, "#/") {
+			return node
+		}
+		var cur interface{} = root
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			m, _ := cur.(map[string]interface{})
+			cur = m[part]
+		}
+		node, _ = cur.(map[string]interface{})
+	}
+	return node
+}
+
+func typeSet(node map[string]interface{}) map[string]struct{} {
+	switch t := node["type"].(type) {
+	case string:
+		return map[string]struct{}{t: {}}
+	case []interface{}:
+		set := map[string]struct{}{}
+		for _, v := range t {
+			if s, ok := v.(string); ok {
+				set[s] = struct{}{}
+			}
+		}
+		return set
+	}
+	return nil
+}
+
+func enumSet(node map[string]interface{}) map[string]struct{} {
+	enum, ok := node["enum"].([]interface{})
+	if !ok {
+		return nil
+	}
+	set := map[string]struct{}{}
+	for _, v := range enum {
+		raw, _ := json.Marshal(v)
+		set[string(raw)] = struct{}{}
+	}
+	return set
+}
+
+func requiredSet(node map[string]interface{}) map[string]struct{} {
+	set := map[string]struct{}{}
+	required, _ := node["required"].([]interface{})
+	for _, v := range required {
+		if s, ok := v.(string); ok {
+			set[s] = struct{}{}
+		}
+	}
+	return set
+}
+
+// difference is the sorted keys of a that are not in b.
+func difference(a, b map[string]struct{}) []string {
+	var diff []string
+	for k := range a {
+		if _, ok := b[k]; !ok {
+			diff = append(diff, k)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
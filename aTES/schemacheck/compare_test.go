@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func parseSchema(t *testing.T, raw string) map[string]interface{} {
+	t.Helper()
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &schema); err != nil {
+		t.Fatalf("bad schema %s: %s", raw, err)
+	}
+	return schema
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     []string
+	}{
+		{
+			name: "same schema",
+			old:  `{"properties": {"title": {"type": "string"}}, "required": ["title"]}`,
+			new:  `{"properties": {"title": {"type": "string"}}, "required": ["title"]}`,
+		},
+		{
+			name: "optional field added",
+			old:  `{"properties": {"title": {"type": "string"}}}`,
+			new:  `{"properties": {"title": {"type": "string"}, "jira_id": {"type": "string"}}}`,
+		},
+		{
+			name: "required field added",
+			old:  `{"properties": {"title": {"type": "string"}}, "required": ["title"]}`,
+			new:  `{"properties": {"title": {"type": "string"}, "jira_id": {"type": "string"}}, "required": ["title", "jira_id"]}`,
+			want: []string{"backward: jira_id: required field added"},
+		},
+		{
+			name: "required field removed",
+			old:  `{"properties": {"full_name": {"type": "string"}}, "required": ["full_name"]}`,
+			new:  `{"properties": {"first_name": {"type": "string"}}}`,
+			want: []string{"forward: full_name: required field removed"},
+		},
+		{
+			name: "field no longer required",
+			old:  `{"properties": {"title": {"type": "string"}}, "required": ["title"]}`,
+			new:  `{"properties": {"title": {"type": "string"}}}`,
+			want: []string{"forward: title: no longer required"},
+		},
+		{
+			name: "type changed",
+			old:  `{"properties": {"cost": {"type": "integer"}}}`,
+			new:  `{"properties": {"cost": {"type": "string"}}}`,
+			want: []string{
+				"backward: cost: type narrowed, integer no longer allowed",
+				"forward: cost: type widened, string now allowed",
+			},
+		},
+		{
+			name: "type widened",
+			old:  `{"properties": {"owner_id": {"type": "string"}}}`,
+			new:  `{"properties": {"owner_id": {"type": ["string", "null"]}}}`,
+			want: []string{"forward: owner_id: type widened, null now allowed"},
+		},
+		{
+			name: "nested type change through ref",
+			old:  `{"definitions": {"data": {"properties": {"status": {"type": "integer"}}}}, "properties": {"data": {"$ref": "#/definitions/data"}}}`,
+			new:  `{"definitions": {"data": {"properties": {"status": {"type": "string"}}}}, "properties": {"data": {"$ref": "#/definitions/data"}}}`,
+			want: []string{
+				"backward: data.status: type narrowed, integer no longer allowed",
+				"forward: data.status: type widened, string now allowed",
+			},
+		},
+		{
+			name: "enum value removed",
+			old:  `{"properties": {"role": {"enum": ["admin", "worker"]}}}`,
+			new:  `{"properties": {"role": {"enum": ["admin"]}}}`,
+			want: []string{"backward: role: enum values removed: \"worker\""},
+		},
+		{
+			name: "event_version bump",
+			old:  `{"properties": {"event_version": {"enum": [1]}}}`,
+			new:  `{"properties": {"event_version": {"enum": [2]}}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old, new := parseSchema(t, tt.old), parseSchema(t, tt.new)
+			c := comparer{oldRoot: old, newRoot: new}
+			c.compare("", old, new)
+			if !reflect.DeepEqual(c.violations, tt.want) {
+				t.Errorf("violations = %q, want %q", c.violations, tt.want)
+			}
+		})
+	}
+}
+
+func TestProblems(t *testing.T) {
+	v1 := `{"properties": {"event_version": {"enum": [1]}, "title": {"type": "string"}}}`
+	v2 := `{"properties": {"event_version": {"enum": [2]}, "title": {"type": "integer"}}}`
+	v2NoBump := `{"properties": {"event_version": {"enum": [1]}, "title": {"type": "integer"}}}`
+
+	tests := []struct {
+		name         string
+		versions     []string
+		wantProblems int
+		wantBreaking bool
+	}{
+		{name: "breaking change with version bump", versions: []string{v1, v2}, wantProblems: 2},
+		// the version mismatch of 2.json and both type violations
+		{name: "breaking change without version bump", versions: []string{v1, v2NoBump}, wantProblems: 3, wantBreaking: true},
+		{name: "single version", versions: []string{v1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			evt := event{name: "tasks/updated"}
+			for i, raw := range tt.versions {
+				evt.versions = append(evt.versions, schemaVersion{
+					version: i + 1,
+					path:    fmt.Sprintf("tasks/updated/%d.json", i+1),
+					schema:  parseSchema(t, raw),
+				})
+			}
+
+			problems := evt.problems()
+			if len(problems) != tt.wantProblems {
+				t.Fatalf("got %d problems %v, want %d", len(problems), problems, tt.wantProblems)
+			}
+			breaking := false
+			for _, p := range problems {
+				breaking = breaking || p.breaking
+			}
+			if breaking != tt.wantBreaking {
+				t.Errorf("breaking = %v, want %v: %v", breaking, tt.wantBreaking, problems)
+			}
+		})
+	}
+}
@@ -0,0 +1,3 @@
+module schemacheck
+
+go 1.18
@@ -0,0 +1,166 @@
+// Command schemacheck walks the event schemas, laid out as
+// <entity>/<event>/<version>.json, and compares every version of an event
+// with the previous one.
+//
+// Changes that break consumers are reported as backward (new consumers can't
+// read old events) or forward (old consumers can't read new events)
+// violations. They are expected between versions, consumers deal with them
+// by upcasting, so the command fails only when a breaking change comes
+// without a version bump, i.e. the event_version declared by the schema is
+// not greater than the one of the previous version.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	dir := flag.String("dir", "../schemas", "event schemas directory")
+	flag.Parse()
+
+	events, err := loadEvents(*dir)
+	if err != nil {
+		fmt.Println("load schemas failed:", err)
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, evt := range events {
+		for _, p := range evt.problems() {
+			fmt.Println(p)
+			failed = failed || p.breaking
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+type schemaVersion struct {
+	version int
+	path    string
+	schema  map[string]interface{}
+}
+
+type event struct {
+	name     string
+	versions []schemaVersion
+}
+
+type problem struct {
+	event    string
+	from, to int
+	msg      string
+	breaking bool
+}
+
+func (p problem) String() string {
+	level := "warning"
+	if p.breaking {
+		level = "error"
+	}
+	if p.from == 0 {
+		return fmt.Sprintf("%s: %s v%d: %s", level, p.event, p.to, p.msg)
+	}
+	return fmt.Sprintf("%s: %s v%d -> v%d: %s", level, p.event, p.from, p.to, p.msg)
+}
+
+func loadEvents(dir string) ([]event, error) {
+	byName := map[string]*event{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+
+		version, err := strconv.Atoi(strings.TrimSuffix(info.Name(), ".json"))
+		if err != nil {
+			return fmt.Errorf("%s: file name is not a version: %w", path, err)
+		}
+		rel, err := filepath.Rel(dir, filepath.Dir(path))
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+
+		raw, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var schema map[string]interface{}
+		if err := json.Unmarshal(raw, &schema); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		if byName[name] == nil {
+			byName[name] = &event{name: name}
+		}
+		byName[name].versions = append(byName[name].versions, schemaVersion{version, path, schema})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]event, 0, len(byName))
+	for _, evt := range byName {
+		sort.Slice(evt.versions, func(i, j int) bool { return evt.versions[i].version < evt.versions[j].version })
+		events = append(events, *evt)
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].name < events[j].name })
+	return events, nil
+}
+
+func (evt event) problems() []problem {
+	var problems []problem
+	for i, cur := range evt.versions {
+		declared, ok := declaredVersion(cur.schema)
+		if !ok {
+			problems = append(problems, problem{event: evt.name, to: cur.version, breaking: true,
+				msg: "event_version is not declared with a single value enum"})
+		} else if declared != cur.version {
+			problems = append(problems, problem{event: evt.name, to: cur.version, breaking: true,
+				msg: fmt.Sprintf("declares event_version %d in %s", declared, filepath.Base(cur.path))})
+		}
+		if i == 0 {
+			continue
+		}
+
+		prev := evt.versions[i-1]
+		prevDeclared, _ := declaredVersion(prev.schema)
+		bumped := ok && declared > prevDeclared
+
+		c := comparer{oldRoot: prev.schema, newRoot: cur.schema}
+		c.compare("", prev.schema, cur.schema)
+		for _, v := range c.violations {
+			msg := v
+			if !bumped {
+				msg += " (no version bump)"
+			}
+			problems = append(problems, problem{event: evt.name, from: prev.version, to: cur.version, msg: msg, breaking: !bumped})
+		}
+	}
+	return problems
+}
+
+// declaredVersion is the only value of properties.event_version.enum.
+func declaredVersion(schema map[string]interface{}) (int, bool) {
+	props, _ := schema["properties"].(map[string]interface{})
+	ev, _ := props["event_version"].(map[string]interface{})
+	enum, _ := ev["enum"].([]interface{})
+	if len(enum) != 1 {
+		return 0, false
+	}
+	v, ok := enum[0].(float64)
+	return int(v), ok
+}
@@ -65,8 +65,10 @@ func (c *Connection) GetAccount(id string) (*JiraAccount, error) {
 		return nil, fmt.Errorf("parse id failed: %w", err)
 	}
 
+	// deleted accounts are looked up too, so events coming after the
+	// deletion don't recreate them
 	var acc JiraAccount
-	res := c.Where(&JiraAccount{PublicID: uid}).First(&acc)
+	res := c.Unscoped().Where(&JiraAccount{PublicID: uid}).First(&acc)
 	if res.Error != nil {
 		if strings.Contains(res.Error.Error(), "record not found") {
 			return &acc, nil
@@ -86,6 +88,22 @@ func (c *Connection) GetAllAccounts() ([]JiraAccount, error) {
 	return allAccs, nil
 }
 
+// GetAllWorkers returns the public ids of the accounts tasks can be assigned to.
+func (c *Connection) GetAllWorkers() ([]uuid.UUID, error) {
+	allAccs, err := c.GetAllAccounts()
+	if err != nil {
+		return nil, err
+	}
+
+	var workers []uuid.UUID
+	for _, a := range allAccs {
+		if a.Role == nil || *a.Role == Role_Worker {
+			workers = append(workers, a.PublicID)
+		}
+	}
+	return workers, nil
+}
+
 func (c *Connection) UpdateAccount(id, role, email string) (*JiraAccount, error) {
 	acc, err := c.GetAccount(id)
 	if err != nil {
@@ -125,3 +143,13 @@ func (c *Connection) CreateAccount(acc *JiraAccount) error {
 	}
 	return nil
 }
+
+// DeleteAccount marks the account deleted, it is no longer listed or assigned
+// tasks to.
+func (c *Connection) DeleteAccount(acc *JiraAccount) error {
+	res := c.Delete(acc)
+	if res.Error != nil {
+		return fmt.Errorf("acc delete failed: %s", res.Error)
+	}
+	return nil
+}
@@ -2,10 +2,14 @@ package db
 
 import (
 	"fmt"
+	"messaging/deadletter"
+	"messaging/inbox"
+	"messaging/outbox"
 
 	_ "github.com/lib/pq"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type Connection struct {
@@ -31,6 +35,22 @@ func Connect() Connection {
 
 	fmt.Println(db.AutoMigrate(&Task{}))
 	fmt.Println(db.AutoMigrate(&JiraAccount{}))
+	fmt.Println(db.AutoMigrate(&outbox.Message{}))
+	fmt.Println(db.AutoMigrate(&inbox.ProcessedEvent{}))
+	fmt.Println(db.AutoMigrate(&deadletter.DeadLetter{}))
 	fmt.Println("Successfully connected!")
 	return Connection{db}
 }
+
+// WithTx runs fn in a single database transaction. Any error returned by fn
+// (or a panic) rolls back everything fn did.
+func (c *Connection) WithTx(fn func(conn Connection) error) error {
+	return c.DB.Transaction(func(tx *gorm.DB) error {
+		return fn(Connection{tx})
+	})
+}
+
+// forUpdate locks the selected rows until the surrounding transaction ends.
+func (c *Connection) forUpdate() *gorm.DB {
+	return c.Clauses(clause.Locking{Strength: "UPDATE"})
+}
@@ -0,0 +1,10 @@
+package db
+
+import "messaging/outbox"
+
+// AddOutboxMessage queues payload for topic in the outbox, so it is published
+// only if the surrounding transaction commits. Messages with the same key end
+// up in the same partition, so their consumers get them in order.
+func (c *Connection) AddOutboxMessage(topic, key string, headers map[string]string, payload []byte) error {
+	return outbox.Add(c.DB, topic, key, headers, payload)
+}
@@ -1,7 +1,10 @@
 package db
 
 import (
+	"errors"
 	"fmt"
+	"regexp"
+	"strings"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
@@ -14,6 +17,40 @@ type Task struct {
 	Status      Status    `json:"status"`
 	Description string    `json:"description"`
 	Title       string    `json:"title"`
+	JiraID      string    `json:"jira_id"`
+}
+
+var (
+	ErrEmptyTitle       = errors.New("title is required")
+	ErrJiraIDInTitle    = errors.New("title must not start with [JIRA-ID], pass it as jira_id")
+	jiraIDInTitlePrefix = regexp.MustCompile(`^\s*\[[^\]]*\]`)
+)
+
+// NewTask makes a task from the user input. Jira id is kept apart from the
+// title, titles like "[JIRA-42] Title" are rejected.
+func NewTask(title, jiraID, descr string) (*Task, error) {
+	title = strings.TrimSpace(title)
+	if title == "" {
+		return nil, ErrEmptyTitle
+	}
+	if jiraIDInTitlePrefix.MatchString(title) {
+		return nil, ErrJiraIDInTitle
+	}
+
+	return &Task{
+		PublicID:    uuid.New(),
+		Title:       title,
+		JiraID:      strings.TrimSpace(jiraID),
+		Description: descr,
+	}, nil
+}
+
+// Name is how the task is shown: "[JIRA-42] Title".
+func (t *Task) Name() string {
+	if t.JiraID != "" {
+		return fmt.Sprintf("[%s] %s", t.JiraID, t.Title)
+	}
+	return t.Title
 }
 
 type Status int
@@ -79,6 +116,17 @@ func (c *Connection) GetAllDoneTasks() ([]Task, error) {
 	return all, nil
 }
 
+// LockOpenTasksByOwner returns the owner's tasks that are not done yet and
+// keeps them from being changed until the surrounding transaction ends.
+func (c *Connection) LockOpenTasksByOwner(ownerID uuid.UUID) ([]Task, error) {
+	tasks := []Task{}
+	res := c.forUpdate().Where("owner_id = ? AND status <> ?", ownerID, Status_Done).Find(&tasks)
+	if res.Error != nil {
+		return nil, fmt.Errorf("get open tasks by owner failed: %s", res.Error)
+	}
+	return tasks, nil
+}
+
 func (c *Connection) UpdateTask(id, descr, status string) (*Task, error) {
 	t, err := c.GetTask(id)
 	if err != nil {
@@ -96,6 +144,14 @@ func (c *Connection) UpdateTask(id, descr, status string) (*Task, error) {
 	return t, c.SaveTask(t)
 }
 
+func (c *Connection) CreateTask(t *Task) error {
+	res := c.Create(t)
+	if res.Error != nil {
+		return fmt.Errorf("task create failed: %s", res.Error)
+	}
+	return nil
+}
+
 func (c *Connection) SaveTask(t *Task) error {
 	res := c.Save(t)
 	if res.Error != nil {
@@ -103,3 +159,42 @@ func (c *Connection) SaveTask(t *Task) error {
 	}
 	return nil
 }
+
+// DeleteTask soft-deletes the task, it is no longer listed, updated or
+// reassigned until it is restored.
+func (c *Connection) DeleteTask(t *Task) error {
+	res := c.Delete(t)
+	if res.Error != nil {
+		return fmt.Errorf("task delete failed: %s", res.Error)
+	}
+	return nil
+}
+
+func (c *Connection) GetDeletedTasks() ([]Task, error) {
+	deleted := []Task{}
+	res := c.Unscoped().Where("deleted_at IS NOT NULL").Order("deleted_at DESC").Find(&deleted)
+	if res.Error != nil {
+		return nil, fmt.Errorf("get deleted tasks failed: %s", res.Error)
+	}
+	return deleted, nil
+}
+
+// RestoreTask brings a deleted task back.
+func (c *Connection) RestoreTask(id string) (*Task, error) {
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, fmt.Errorf("parse id failed: %w", err)
+	}
+
+	var task Task
+	res := c.Unscoped().Where("public_id = ? AND deleted_at IS NOT NULL", uid).First(&task)
+	if res.Error != nil {
+		return nil, fmt.Errorf("get deleted task failed: %w", res.Error)
+	}
+
+	res = c.Unscoped().Model(&task).Update("deleted_at", nil)
+	if res.Error != nil {
+		return nil, fmt.Errorf("task restore failed: %s", res.Error)
+	}
+	return &task, nil
+}
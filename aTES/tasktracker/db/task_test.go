@@ -0,0 +1,31 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestNewTask(t *testing.T) {
+	tests := []struct {
+		title, jiraID string
+		want          error
+		wantName      string
+	}{
+		{title: " fix login ", jiraID: " POPUG-42 ", wantName: "[POPUG-42] fix login"},
+		{title: "fix login", wantName: "fix login"},
+		{title: "  ", jiraID: "POPUG-42", want: ErrEmptyTitle},
+		{title: "[POPUG-42] fix login", want: ErrJiraIDInTitle},
+		{title: " [POPUG-42] fix login", jiraID: "POPUG-42", want: ErrJiraIDInTitle},
+	}
+
+	for _, tt := range tests {
+		task, err := NewTask(tt.title, tt.jiraID, "")
+		if !errors.Is(err, tt.want) {
+			t.Errorf("NewTask(%q, %q): got error %v, want %v", tt.title, tt.jiraID, err, tt.want)
+			continue
+		}
+		if err == nil && task.Name() != tt.wantName {
+			t.Errorf("NewTask(%q, %q): name %q, want %q", tt.title, tt.jiraID, task.Name(), tt.wantName)
+		}
+	}
+}
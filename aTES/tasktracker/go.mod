@@ -35,3 +35,13 @@ require (
 	google.golang.org/appengine v1.6.6 // indirect
 	google.golang.org/protobuf v1.25.0 // indirect
 )
+
+require (
+	events v0.0.0
+	messaging v0.0.0
+)
+
+replace (
+	events => ../events
+	messaging => ../messaging
+)
@@ -2,45 +2,88 @@ package consumer
 
 import (
 	"encoding/json"
+	"events"
 	"fmt"
+	"log"
+	"math/rand"
+	"messaging/router"
+	"messaging/validation"
+	"messaging/versioning"
 	"os"
+	"strings"
 	"tasktracker/db"
-	"time"
+	"tasktracker/kafka/producer"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/dkolistratova/eventschemaregistry"
 	"github.com/google/uuid"
 )
 
 const (
-	AccountEventsTopic = "accounts"
-	AccountCUDsTopic   = "accounts-stream"
+	// RetryTopic holds events waiting for another processing attempt and
+	// DeadLetterTopic the ones that failed every attempt. QuarantineTopic
+	// holds events that don't match their schema, they are never applied.
+	RetryTopic      = "tasktracker-retry"
+	DeadLetterTopic = "tasktracker-dead-letters"
+	QuarantineTopic = "tasktracker-quarantine"
+)
 
-	AccountCreatedEvt = "AccountCreated"
-	AccountUpdatedEvt = "AccountUpdated"
-	AccountDeletedEvt = "AccountDeleted"
+// Topics are the topics this consumer reads from or reroutes failed events to.
+var Topics = []string{events.AccountEventsTopic, events.AccountCUDsTopic, RetryTopic, DeadLetterTopic, QuarantineTopic}
 
-	AccountRoleChangedEvt = "AccountRoleChanged"
-)
+// ErrInvalidEvent marks events that can never be applied. They are
+// quarantined at once, as retrying them can't help.
+var ErrInvalidEvent = router.ErrInvalidEvent
+
+// Config sizes the worker pool and sets how many times a failing event is
+// processed.
+type Config = router.Config
 
 type Consumer struct {
 	DBConn              db.Connection
 	RoleChangesNotifyer chan uuid.UUID
+	Producer            *producer.Producer
+
+	processors map[string]processor
+	router     *router.Router
+	validator  *eventschemaregistry.Validator
 }
 
-func NewConsumer(dbConn db.Connection, roleChangesNotifyer chan uuid.UUID) *Consumer {
-	return &Consumer{
+func NewConsumer(dbConn db.Connection, roleChangesNotifyer chan uuid.UUID, p *producer.Producer, cfg Config) *Consumer {
+	c := &Consumer{
 		DBConn:              dbConn,
 		RoleChangesNotifyer: roleChangesNotifyer,
+		Producer:            p,
+		validator:           eventschemaregistry.NewValidator("/app/event_schema_registry/schemas"),
+	}
+	c.processors = map[string]processor{
+		events.AccountEventsTopic: c.processAccountEvts,
+		events.AccountCUDsTopic:   c.processAccountsCUDs,
 	}
+
+	handlers := map[string]router.Handler{}
+	for topic, proc := range c.processors {
+		proc := proc
+		handlers[topic] = func(msg *kafka.Message) error {
+			return c.handle(proc, msg)
+		}
+	}
+	c.router = router.New(dbConn.DB, router.Topics{
+		Retry:      RetryTopic,
+		DeadLetter: DeadLetterTopic,
+		Quarantine: QuarantineTopic,
+	}, handlers, cfg)
+	return c
 }
 
 func (consumer *Consumer) Run() {
 	conf := kafka.ConfigMap{
-		"bootstrap.servers":     "broker:29092",
-		"broker.address.family": "v4",
-		"group.id":              1,
-		"session.timeout.ms":    6000,
-		"auto.offset.reset":     "earliest",
+		"bootstrap.servers":        "broker:29092",
+		"broker.address.family":    "v4",
+		"group.id":                 1,
+		"session.timeout.ms":       6000,
+		"auto.offset.reset":        "earliest",
+		"enable.auto.offset.store": false,
 	}
 	c, err := kafka.NewConsumer(&conf)
 	if err != nil {
@@ -50,52 +93,87 @@ func (consumer *Consumer) Run() {
 
 	defer c.Close()
 
-	topicProcessors := map[string]func(msg *kafka.Message) error{
-		AccountEventsTopic: consumer.processAccountEvts,
-		AccountCUDsTopic:   consumer.processAccountsCUDs,
-	}
+	defer consumer.router.Close()
 
-	var topics []string
-	for k := range topicProcessors {
+	topics := []string{}
+	for k := range consumer.processors {
 		topics = append(topics, k)
 	}
-
 	if err := c.SubscribeTopics(topics, nil); err != nil {
 		fmt.Println(err)
 	}
 
-	for {
-		ev, err := c.ReadMessage(time.Second * 10)
-		if err != nil {
-			continue
-		}
-		fmt.Printf("Consumed event from topic %s: key = %-10s value = %s\n",
-			*ev.TopicPartition.Topic, string(ev.Key), string(ev.Value))
-		if processor, ok := topicProcessors[*ev.TopicPartition.Topic]; ok {
-			go func() {
-				fmt.Println(processor(ev))
-			}()
-		}
-	}
+	go consumer.router.RunRetries()
+	consumer.router.Consume(c)
 }
 
-func (c *Consumer) processAccountEvts(msg *kafka.Message) error {
-	var payload Event
-	if err := json.Unmarshal(msg.Value, &payload); err != nil {
+// Redrive processes a dead-lettered event again with payload replacing the
+// original one, see Router.Redrive.
+func (consumer *Consumer) Redrive(id string, payload []byte) error {
+	return consumer.router.Redrive(id, payload)
+}
+
+// processor handles an event already upcast to the latest version.
+type processor func(conn db.Connection, evt *events.Event) error
+
+// handle applies the event atomically: every change made by the processor
+// and the inbox record are committed or none of them is. Events already in
+// the inbox are skipped.
+func (c *Consumer) handle(processor processor, msg *kafka.Message) error {
+	var evt events.Event
+	if err := json.Unmarshal(msg.Value, &evt); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidEvent, err)
+	}
+	if !versioning.IsPreferredCopy(msg, evt.EventName) {
+		fmt.Println("skipping copy of", &evt)
+		return nil
+	}
+	if err := validation.Validate(c.validator, &evt, msg.Value); err != nil {
+		return err
+	}
+	if err := versioning.Upcast(&evt); err != nil {
 		return err
 	}
 
-	switch payload.EventName {
-	case AccountRoleChangedEvt:
-		var data RoleChangePayload
-		if err := json.Unmarshal([]byte(msg.Value), &data); err != nil {
+	return c.DBConn.WithTx(func(conn db.Connection) error {
+		if evt.EventID == "" {
+			log.Println("event without event_id, can't deduplicate", &evt)
+		} else {
+			first, err := conn.MarkEventProcessed(evt.EventID, evt.EventName, *msg.TopicPartition.Topic)
+			if err != nil {
+				return err
+			}
+			if !first {
+				fmt.Println("skipping already processed event", &evt)
+				return nil
+			}
+		}
+
+		fmt.Println("handling", &evt)
+		return processor(conn, &evt)
+	})
+}
+
+// Seen reports whether the event has already been processed.
+func (c *Consumer) Seen(eventID string) (bool, error) {
+	return c.DBConn.IsEventProcessed(eventID)
+}
+
+func (c *Consumer) processAccountEvts(conn db.Connection, evt *events.Event) error {
+	switch evt.EventName {
+	case events.AccountRoleChangedEvt:
+		var data events.AccountRoleChangedV1
+		if err := json.Unmarshal(evt.Data, &data); err != nil {
 			return err
 		}
 
-		acc, err := c.DBConn.GetAccount(data.Data.PublicID)
+		acc, err := conn.GetAccount(data.PublicID)
 		if err != nil {
 			return err
 		}
+		if acc.DeletedAt.Valid {
+			return nil
+		}
 
 		defer func() {
 			if c.RoleChangesNotifyer != nil {
@@ -103,92 +181,130 @@ func (c *Consumer) processAccountEvts(msg *kafka.Message) error {
 			}
 		}()
 
-		acc.Role.UnmarshalText(data.Data.Role)
-		return c.DBConn.SaveAccount(acc)
+		acc.Role.UnmarshalText(data.Role)
+		return conn.SaveAccount(acc)
 	default:
-		fmt.Println("ignoring unknown event", payload.EventName)
+		fmt.Println("ignoring unknown event", evt)
 	}
 
 	return nil
 }
 
-func (c *Consumer) processAccountsCUDs(msg *kafka.Message) error {
-	var payload Event
-	if err := json.Unmarshal(msg.Value, &payload); err != nil {
-		return err
-	}
-
-	switch payload.EventName {
-	case AccountUpdatedEvt:
-		var data AccountUpdatePayload
-		if err := json.Unmarshal([]byte(msg.Value), &data); err != nil {
+func (c *Consumer) processAccountsCUDs(conn db.Connection, evt *events.Event) error {
+	switch evt.EventName {
+	case events.AccountUpdatedEvt:
+		var data events.AccountUpdatedV1
+		if err := json.Unmarshal(evt.Data, &data); err != nil {
 			return err
 		}
 
-		acc, err := c.DBConn.GetAccount(data.Data.PublicID)
+		acc, err := conn.GetAccount(data.PublicID)
 		if err != nil {
 			return err
 		}
 
 		if acc.ID == 0 {
-			uid, err := uuid.Parse(data.Data.PublicID)
+			uid, err := uuid.Parse(data.PublicID)
 			if err != nil {
 				return err
 			}
 
-			return c.DBConn.CreateAccount(&db.JiraAccount{
+			return conn.CreateAccount(&db.JiraAccount{
 				PublicID: uid,
-				Email:    data.Data.Email,
-				FullName: data.Data.FullName,
+				Email:    data.Email,
+				FullName: data.FullName,
 			})
 		}
 
-		acc.Email = data.Data.Email
-		acc.FullName = data.Data.FullName
-		return c.DBConn.SaveAccount(acc)
-	case AccountCreatedEvt:
-		var data AccountUpdatePayload
-		if err := json.Unmarshal([]byte(msg.Value), &data); err != nil {
+		if acc.DeletedAt.Valid {
+			return nil
+		}
+
+		acc.Email = data.Email
+		acc.FullName = data.FullName
+		return conn.SaveAccount(acc)
+	case events.AccountCreatedEvt:
+		var data events.AccountCreatedV2
+		if err := json.Unmarshal(evt.Data, &data); err != nil {
 			return err
 		}
 
-		uid, err := uuid.Parse(data.Data.PublicID)
+		uid, err := uuid.Parse(data.PublicID)
 		if err != nil {
 			return err
 		}
 
-		return c.DBConn.CreateAccount(&db.JiraAccount{
+		return conn.CreateAccount(&db.JiraAccount{
 			PublicID: uid,
-			Email:    data.Data.Email,
-			FullName: data.Data.FullName,
+			Email:    data.Email,
+			FullName: strings.TrimSpace(data.FirstName + " " + data.LastName),
 		})
+	case events.AccountDeletedEvt:
+		var data events.AccountDeletedV1
+		if err := json.Unmarshal(evt.Data, &data); err != nil {
+			return err
+		}
+
+		acc, err := conn.GetAccount(data.PublicID)
+		if err != nil {
+			return err
+		}
+		if acc.DeletedAt.Valid {
+			return nil
+		}
+		// keep the deleted account around, so a late AccountCreated doesn't
+		// bring it back
+		if acc.ID == 0 {
+			if acc.PublicID, err = uuid.Parse(data.PublicID); err != nil {
+				return err
+			}
+			if err := conn.CreateAccount(acc); err != nil {
+				return err
+			}
+		}
+		if err := conn.DeleteAccount(acc); err != nil {
+			return err
+		}
+
+		return c.reassignTasks(conn, evt, acc.PublicID)
 	default:
-		fmt.Println("ignoring unknown event", payload.EventName)
+		fmt.Println("ignoring unknown event", evt)
 	}
 
 	return nil
 }
 
-type Event struct {
-	EventID      string `json:"event_id"`
-	EventVersion int64  `json:"event_version"`
-	EventTime    string `json:"event_time"`
-	Producer     string `json:"producer"`
-	EventName    string `json:"event_name"`
-}
+// reassignTasks hands the open tasks of the deleted owner out to the
+// remaining workers. The tasks stay with the deleted owner if there is
+// nobody left to take them.
+func (c *Consumer) reassignTasks(conn db.Connection, evt *events.Event, ownerID uuid.UUID) error {
+	tasks, err := conn.LockOpenTasksByOwner(ownerID)
+	if err != nil || len(tasks) == 0 {
+		return err
+	}
 
-type AccountUpdatePayload struct {
-	Data struct {
-		PublicID string `json:"public_id"`
-		Email    string `json:"email"`
-		FullName string `json:"full_name"`
-		Position string `json:"position"`
-	} `json:"data"`
-}
+	workers, err := conn.GetAllWorkers()
+	if err != nil {
+		return err
+	}
+	if len(workers) == 0 {
+		log.Println("no workers left to reassign", len(tasks), "tasks of", ownerID)
+		return nil
+	}
 
-type RoleChangePayload struct {
-	Data struct {
-		PublicID string `json:"public_id"`
-		Role     string `json:"role"`
-	} `json:"data"`
+	meta := events.CausedBy(evt)
+	for _, t := range tasks {
+		t.OwnerID = workers[rand.Intn(len(workers))]
+		if err := conn.SaveTask(&t); err != nil {
+			return err
+		}
+		if err := c.Producer.TaskUpdatedMsg(conn, meta, t); err != nil {
+			return err
+		}
+		if err := c.Producer.TaskAssignedMsg(conn, meta, t); err != nil {
+			return err
+		}
+	}
+	log.Println("reassigned", len(tasks), "tasks of deleted account", ownerID)
+	return nil
 }
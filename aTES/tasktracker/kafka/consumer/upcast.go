@@ -0,0 +1,25 @@
+package consumer
+
+import (
+	"events"
+	"messaging/versioning"
+	"strings"
+)
+
+func init() {
+	versioning.Register(events.AccountCreatedEvt, 1, accountCreatedV1ToV2)
+}
+
+// accountCreatedV1ToV2 splits full_name of v1 into first_name and last_name.
+func accountCreatedV1ToV2(data map[string]interface{}) error {
+	fullName, _ := data["full_name"].(string)
+	delete(data, "full_name")
+
+	first, last := fullName, ""
+	if i := strings.IndexByte(fullName, ' '); i >= 0 {
+		first, last = fullName[:i], strings.TrimSpace(fullName[i+1:])
+	}
+	data["first_name"] = first
+	data["last_name"] = last
+	return nil
+}
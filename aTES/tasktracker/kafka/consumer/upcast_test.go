@@ -0,0 +1,24 @@
+package consumer
+
+import (
+	"encoding/json"
+	"events"
+	"messaging/versioning"
+	"testing"
+)
+
+func TestUpcastAccountCreatedV1(t *testing.T) {
+	data, _ := json.Marshal(map[string]interface{}{"public_id": "acc-1", "full_name": "Ivan Ivanovich Popugaev"})
+	evt := &events.Event{EventName: events.AccountCreatedEvt, EventVersion: 1, Data: data}
+	if err := versioning.Upcast(evt); err != nil {
+		t.Fatal(err)
+	}
+
+	var got events.AccountCreatedV2
+	if err := json.Unmarshal(evt.Data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if evt.EventVersion != 2 || got.FirstName != "Ivan" || got.LastName != "Ivanovich Popugaev" {
+		t.Errorf("got v%d %q %q, want v2 Ivan, Ivanovich Popugaev", evt.EventVersion, got.FirstName, got.LastName)
+	}
+}
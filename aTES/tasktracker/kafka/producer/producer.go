@@ -2,39 +2,47 @@ package producer
 
 import (
 	"encoding/json"
+	"events"
 	"fmt"
+	"messaging/outbox"
+	"messaging/versioning"
 	"os"
 	"tasktracker/db"
-	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/dkolistratova/eventschemaregistry"
 	"github.com/google/uuid"
 )
 
-const (
-	TaskEventsTopic = "tasks"
-	TaskCUDsTopic   = "tasks-stream"
-
-	TaskCreatedEvt = "TaskCreated"
-	TaskUpdatedEvt = "TaskUpdated"
-	TaskDeletedEvt = "TaskDeleted"
+// DefaultVersions are the schema versions each event is published in, v2 of
+// the task events carries jira_id apart from the title.
+var DefaultVersions = versioning.Versions{
+	events.TaskCreatedEvt:   {2},
+	events.TaskUpdatedEvt:   {2},
+	events.TaskDeletedEvt:   {2},
+	events.TaskAssignedEvt:  {2},
+	events.TaskCompletedEvt: {2},
+	events.TaskRestoredEvt:  {1},
+}
 
-	TaskAssignedEvt  = "TaskAssigned"
-	TaskCompletedEvt = "TaskCompleted"
-)
+// Topics are the topics this producer writes to.
+var Topics = []string{events.TaskEventsTopic, events.TaskCUDsTopic}
 
 type Producer struct {
 	*kafka.Producer
-	deliveryCh chan kafka.Event
-	validator  *eventschemaregistry.Validator
+	dbConn    db.Connection
+	validator *eventschemaregistry.Validator
+
+	// Versions list both versions of an event while its schema is migrated.
+	Versions versioning.Versions
 }
 
-func NewProducer() *Producer {
+func NewProducer(dbConn db.Connection) *Producer {
 	conf := kafka.ConfigMap{
-		"bootstrap.servers": "broker:29092",
-		"client.id":         "localhost:3002",
-		"acks":              "all",
+		"bootstrap.servers":  "broker:29092",
+		"client.id":          "localhost:3002",
+		"acks":               "all",
+		"enable.idempotence": true,
 	}
 
 	pr, err := kafka.NewProducer(&conf)
@@ -44,90 +52,127 @@ func NewProducer() *Producer {
 	}
 
 	return &Producer{
-		Producer:   pr,
-		deliveryCh: make(chan kafka.Event, 10000),
-		validator:  eventschemaregistry.NewValidator("/app/event_schema_registry/schemas"),
+		Producer:  pr,
+		dbConn:    dbConn,
+		validator: eventschemaregistry.NewValidator("/app/event_schema_registry/schemas"),
+		Versions:  DefaultVersions,
 	}
 }
 
+// Run relays the outbox to kafka until the process exits.
 func (p *Producer) Run() {
-	defer p.Producer.Close()
-
-	go func() {
-		for {
-			for e := range p.Events() {
-				switch ev := e.(type) {
-				case *kafka.Message:
-					if ev.TopicPartition.Error != nil {
-						fmt.Printf("Failed to deliver message: %v\n", ev.TopicPartition)
-					} else {
-						fmt.Printf("Successfully produced record to topic %s partition [%d] @ offset %v\n",
-							*ev.TopicPartition.Topic, ev.TopicPartition.Partition, ev.TopicPartition.Offset)
-					}
-				}
-			}
-		}
-	}()
+	outbox.NewRelay(p.Producer, p.dbConn.DB).Run()
 }
 
-var validatorByEvtName = map[string]string{
-	TaskAssignedEvt:  "tasks.assigned",
-	TaskCompletedEvt: "tasks.completed",
-	TaskCreatedEvt:   "tasks.created",
-	TaskDeletedEvt:   "tasks.deleted",
-	TaskUpdatedEvt:   "tasks.updated",
+// taskV1 keeps the jira id in the title, as v1 of the task events has no jira_id.
+func taskV1(t db.Task) events.TaskCreatedV1 {
+	return events.TaskCreatedV1{
+		PublicID:    t.PublicID.String(),
+		OwnerID:     t.OwnerID.String(),
+		Status:      int(t.Status),
+		Title:       t.Name(),
+		Description: t.Description,
+	}
 }
 
-func (p *Producer) produceTaskEvt(t db.Task, topic, evtName string) error {
-	msg, err := json.Marshal(Event{
-		EventID:      uuid.NewString(),
-		EventVersion: 1,
-		EventTime:    time.Now().String(),
-		EventName:    evtName,
-		Data:         t,
-	})
-	if err != nil {
-		fmt.Println("produceTaskEvt err", err)
-		return err
+func taskV2(t db.Task) events.TaskCreatedV2 {
+	return events.TaskCreatedV2{
+		PublicID:    t.PublicID.String(),
+		OwnerID:     t.OwnerID.String(),
+		Status:      int(t.Status),
+		Title:       t.Title,
+		JiraID:      t.JiraID,
+		Description: t.Description,
 	}
-	fmt.Println("produceTaskEvt Validate")
-	if err := p.validator.Validate(msg, validatorByEvtName[evtName], 1); err != nil {
-		fmt.Println("produceTaskEvt validation failed", err)
-		return err
+}
+
+// taskDataByVersion shapes a task for each event and schema version. All the
+// task events share the payload, so it is converted to the event's own type.
+var taskDataByVersion = map[string]map[int]func(t db.Task) interface{}{
+	events.TaskCreatedEvt: {
+		1: func(t db.Task) interface{} { return taskV1(t) },
+		2: func(t db.Task) interface{} { return taskV2(t) },
+	},
+	events.TaskUpdatedEvt: {
+		1: func(t db.Task) interface{} { return events.TaskUpdatedV1(taskV1(t)) },
+		2: func(t db.Task) interface{} { return events.TaskUpdatedV2(taskV2(t)) },
+	},
+	events.TaskDeletedEvt: {
+		1: func(t db.Task) interface{} { return events.TaskDeletedV1(taskV1(t)) },
+		2: func(t db.Task) interface{} { return events.TaskDeletedV2(taskV2(t)) },
+	},
+	events.TaskRestoredEvt: {
+		1: func(t db.Task) interface{} { return events.TaskRestoredV1(taskV2(t)) },
+	},
+	events.TaskAssignedEvt: {
+		1: func(t db.Task) interface{} { return events.TaskAssignedV1(taskV1(t)) },
+		2: func(t db.Task) interface{} { return events.TaskAssignedV2(taskV2(t)) },
+	},
+	events.TaskCompletedEvt: {
+		1: func(t db.Task) interface{} { return events.TaskCompletedV1(taskV1(t)) },
+		2: func(t db.Task) interface{} { return events.TaskCompletedV2(taskV2(t)) },
+	},
+}
+
+// produceTaskEvt validates the event and puts it into the outbox using conn,
+// so it is published only if the surrounding transaction commits. Events are
+// keyed by the task, so all events of one task are delivered in order. A copy
+// is published for every configured version, all of them share the event id.
+// meta links the event to the user action it was produced for.
+func (p *Producer) produceTaskEvt(conn db.Connection, meta events.Meta, t db.Task, topic, evtName string) error {
+	versions := p.Versions[evtName]
+	if len(versions) == 0 {
+		return fmt.Errorf("no versions configured for %s", evtName)
+	}
+
+	eventID := uuid.NewString()
+	for _, version := range versions {
+		data, ok := taskDataByVersion[evtName][version]
+		if !ok {
+			return fmt.Errorf("unsupported %s version %d", evtName, version)
+		}
+
+		evt, err := events.New(eventID, "tasktracker", evtName, version, meta, data(t))
+		if err != nil {
+			fmt.Println("produceTaskEvt err", err)
+			return err
+		}
+		msg, err := json.Marshal(evt)
+		if err != nil {
+			fmt.Println("produceTaskEvt err", err)
+			return err
+		}
+		if err := p.validator.Validate(msg, events.SchemaByEvt[evtName], version); err != nil {
+			fmt.Println("produceTaskEvt validation failed", evtName, version, err)
+			return err
+		}
+		if err := conn.AddOutboxMessage(topic, t.PublicID.String(), p.Versions.Headers(evtName, version), msg); err != nil {
+			return err
+		}
 	}
-	fmt.Println("produceTaskEvt Produce")
-	return p.Produce(&kafka.Message{
-		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
-		Value:          msg},
-		p.deliveryCh,
-	)
+	return nil
 }
 
-func (p *Producer) TaskCreatedMsg(t db.Task) error {
-	return p.produceTaskEvt(t, TaskCUDsTopic, TaskCreatedEvt)
+func (p *Producer) TaskCreatedMsg(conn db.Connection, meta events.Meta, t db.Task) error {
+	return p.produceTaskEvt(conn, meta, t, events.TaskCUDsTopic, events.TaskCreatedEvt)
 }
 
-func (p *Producer) TaskUpdatedMsg(t db.Task) error {
-	return p.produceTaskEvt(t, TaskCUDsTopic, TaskUpdatedEvt)
+func (p *Producer) TaskUpdatedMsg(conn db.Connection, meta events.Meta, t db.Task) error {
+	return p.produceTaskEvt(conn, meta, t, events.TaskCUDsTopic, events.TaskUpdatedEvt)
 }
 
-func (p *Producer) TaskDeletedMsg(t db.Task) error {
-	return p.produceTaskEvt(t, TaskCUDsTopic, TaskDeletedEvt)
+func (p *Producer) TaskDeletedMsg(conn db.Connection, meta events.Meta, t db.Task) error {
+	return p.produceTaskEvt(conn, meta, t, events.TaskCUDsTopic, events.TaskDeletedEvt)
 }
 
-func (p *Producer) TaskCompletedMsg(t db.Task) error {
-	return p.produceTaskEvt(t, TaskEventsTopic, TaskCompletedEvt)
+func (p *Producer) TaskRestoredMsg(conn db.Connection, meta events.Meta, t db.Task) error {
+	return p.produceTaskEvt(conn, meta, t, events.TaskCUDsTopic, events.TaskRestoredEvt)
 }
 
-func (p *Producer) TaskAssignedMsg(t db.Task) error {
-	return p.produceTaskEvt(t, TaskEventsTopic, TaskAssignedEvt)
+func (p *Producer) TaskCompletedMsg(conn db.Connection, meta events.Meta, t db.Task) error {
+	return p.produceTaskEvt(conn, meta, t, events.TaskEventsTopic, events.TaskCompletedEvt)
 }
 
-type Event struct {
-	EventID      string      `json:"event_id"`
-	EventVersion int64       `json:"event_version"`
-	EventTime    string      `json:"event_time"`
-	Producer     string      `json:"producer"`
-	EventName    string      `json:"event_name"`
-	Data         interface{} `json:"data"`
+func (p *Producer) TaskAssignedMsg(conn db.Connection, meta events.Meta, t db.Task) error {
+	return p.produceTaskEvt(conn, meta, t, events.TaskEventsTopic, events.TaskAssignedEvt)
 }
@@ -0,0 +1,51 @@
+package producer
+
+import (
+	"events"
+	"tasktracker/db"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestTaskData(t *testing.T) {
+	task := db.Task{PublicID: uuid.New(), OwnerID: uuid.New(), Title: "fix login", JiraID: "POPUG-42", Status: db.Status_Done}
+
+	v1 := taskV1(task)
+	// v1 consumers find the jira id in the title, as before the split
+	if v1.Title != "[POPUG-42] fix login" || v1.PublicID != task.PublicID.String() || v1.Status != int(db.Status_Done) {
+		t.Errorf("v1: got %+v", v1)
+	}
+	v2 := taskV2(task)
+	if v2.Title != "fix login" || v2.JiraID != "POPUG-42" || v2.OwnerID != task.OwnerID.String() {
+		t.Errorf("v2: got %+v", v2)
+	}
+}
+
+func TestTaskDataByVersion(t *testing.T) {
+	// every version published by default must have a payload
+	for evtName, versions := range DefaultVersions {
+		for _, v := range versions {
+			if _, ok := taskDataByVersion[evtName][v]; !ok {
+				t.Errorf("no %s v%d payload", evtName, v)
+			}
+		}
+	}
+
+	for evtName, byVersion := range taskDataByVersion {
+		for v := range byVersion {
+			if !containsInt(events.VersionsByEvt[evtName], v) {
+				t.Errorf("%s v%d has no schema", evtName, v)
+			}
+		}
+	}
+}
+
+func containsInt(vs []int, v int) bool {
+	for _, x := range vs {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
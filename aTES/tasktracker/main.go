@@ -3,9 +3,12 @@ package main
 import (
 	"encoding/json"
 	"errors"
+	"events"
 	"io/ioutil"
 	"log"
 	"math/rand"
+	"messaging/admin"
+	"messaging/router"
 	"net/http"
 	"sync"
 	"tasktracker/db"
@@ -42,13 +45,14 @@ func init() {
 	accountsToUpdate = make(map[uuid.UUID]bool)
 	globalSessions, _ = session.NewManager("memory", "gosessionid", 3600)
 	go globalSessions.GC()
-	t = template.Must(template.ParseFiles("templates/task_list.html", "templates/task_create.html"))
+	t = template.Must(template.ParseFiles("templates/task_list.html", "templates/task_create.html", "templates/dead_letters.html", "templates/deleted_tasks.html"))
 }
 
 type Server struct {
 	*webserver.Server
 	dbConn   db.Connection
 	producer *producer.Producer
+	consumer *consumer.Consumer
 }
 
 func main() {
@@ -56,6 +60,11 @@ func main() {
 		Server: webserver.New(port),
 		dbConn: db.Connect(),
 	}
+	topics := append(producer.Topics, consumer.Topics...)
+	if err := admin.EnsureTopics(admin.Topics(topics...)); err != nil {
+		panic(err)
+	}
+
 	srv.AddHandler("/login", login)
 	srv.AddHandler("/oauth2", oauth)
 
@@ -63,27 +72,32 @@ func main() {
 	srv.AddHandle("/create", authHandler(http.HandlerFunc(srv.createTask)))
 	srv.AddHandle("/update", authHandler(http.HandlerFunc(srv.updateTask)))
 	srv.AddHandle("/shuffle", authHandler(http.HandlerFunc(srv.shuffleTasks)))
+	srv.AddHandle("/delete", managerHandler(http.HandlerFunc(srv.deleteTask)))
+	srv.AddHandle("/admin/dead-letters", adminHandler(http.HandlerFunc(srv.listDeadLetters)))
+	srv.AddHandle("/admin/dead-letters/redrive", adminHandler(http.HandlerFunc(srv.redriveDeadLetter)))
+	srv.AddHandle("/admin/tasks/deleted", adminHandler(http.HandlerFunc(srv.listDeletedTasks)))
+	srv.AddHandle("/admin/tasks/restore", adminHandler(http.HandlerFunc(srv.restoreTask)))
 
+	ch := make(chan uuid.UUID, 10)
 	go func() {
-		ch := make(chan uuid.UUID, 10)
-		go func() {
-			for {
-				uid := <-ch
+		for {
+			uid := <-ch
 
-				mux.Lock()
-				accountsToUpdate[uid] = true
-				mux.Unlock()
-			}
-		}()
-
-		consumer.NewConsumer(srv.dbConn, ch).Run()
+			mux.Lock()
+			accountsToUpdate[uid] = true
+			mux.Unlock()
+		}
 	}()
-
-	srv.producer = producer.NewProducer()
+	srv.producer = producer.NewProducer(srv.dbConn)
 	go func() {
 		srv.producer.Run()
 	}()
 
+	srv.consumer = consumer.NewConsumer(srv.dbConn, ch, srv.producer, router.ConfigFromEnv())
+	go func() {
+		srv.consumer.Run()
+	}()
+
 	log.Println("Running server....", "port", port)
 	log.Println(srv.Run())
 }
@@ -101,6 +115,48 @@ func authHandler(next http.Handler) http.Handler {
 	})
 }
 
+// adminHandler lets through signed in admins only.
+func adminHandler(next http.Handler) http.Handler {
+	return authHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := getCurrentUser(w, r)
+		if err != nil {
+			internalError(w)
+			return
+		}
+
+		if user.Role == nil || *user.Role != db.Role_Admin {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Forbidden"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// managerHandler lets through signed in managers and admins only.
+func managerHandler(next http.Handler) http.Handler {
+	return authHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := getCurrentUser(w, r)
+		if err != nil {
+			internalError(w)
+			return
+		}
+
+		if !canManageTasks(user) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("Forbidden"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	}))
+}
+
+func canManageTasks(user *db.JiraAccount) bool {
+	return user.Role != nil && (*user.Role == db.Role_Admin || *user.Role == db.Role_Manager)
+}
+
 func getUserInfo(accessToken string) *db.JiraAccount {
 	req, err := http.NewRequest("GET", "http://oauth:3000/accounts/current", nil)
 	if err != nil {
@@ -151,6 +207,91 @@ func getCurrentUser(w http.ResponseWriter, r *http.Request) (*db.JiraAccount, er
 	return nil, errors.New("bad val in acc session store")
 }
 
+func (srv *Server) listDeadLetters(w http.ResponseWriter, r *http.Request) {
+	dls, err := srv.dbConn.GetFailedDeadLetters()
+	if err != nil {
+		log.Println("failed to get dead letters", err)
+		internalError(w)
+		return
+	}
+	t.ExecuteTemplate(w, "dead_letters", dls)
+}
+
+func (srv *Server) redriveDeadLetter(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.ParseForm()
+	if err := srv.consumer.Redrive(r.Form.Get("public_id"), []byte(r.Form.Get("payload"))); err != nil {
+		log.Println("failed to redrive dead letter", err)
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte("Re-drive failed: " + err.Error()))
+		return
+	}
+	http.Redirect(w, r, "/admin/dead-letters", http.StatusSeeOther)
+}
+
+func (srv *Server) deleteTask(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.ParseForm()
+	meta := events.Meta{CorrelationID: uuid.NewString()}
+	err := srv.dbConn.WithTx(func(conn db.Connection) error {
+		t, err := conn.GetTask(r.Form.Get("public_id"))
+		if err != nil {
+			return err
+		}
+		if err := conn.DeleteTask(t); err != nil {
+			return err
+		}
+		return srv.producer.TaskDeletedMsg(conn, meta, *t)
+	})
+	if err != nil {
+		log.Println("failed to delete task", err)
+		internalError(w)
+		return
+	}
+	http.Redirect(w, r, "/tasks", http.StatusSeeOther)
+}
+
+func (srv *Server) listDeletedTasks(w http.ResponseWriter, r *http.Request) {
+	tasks, err := srv.dbConn.GetDeletedTasks()
+	if err != nil {
+		log.Println("failed to get deleted tasks", err)
+		internalError(w)
+		return
+	}
+	t.ExecuteTemplate(w, "deleted_tasks", tasks)
+}
+
+func (srv *Server) restoreTask(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.ParseForm()
+	meta := events.Meta{CorrelationID: uuid.NewString()}
+	err := srv.dbConn.WithTx(func(conn db.Connection) error {
+		t, err := conn.RestoreTask(r.Form.Get("public_id"))
+		if err != nil {
+			return err
+		}
+		return srv.producer.TaskRestoredMsg(conn, meta, *t)
+	})
+	if err != nil {
+		log.Println("failed to restore task", err)
+		internalError(w)
+		return
+	}
+	http.Redirect(w, r, "/admin/tasks/deleted", http.StatusSeeOther)
+}
+
 func (srv *Server) shuffleTasks(w http.ResponseWriter, r *http.Request) {
 	allTasks, err := srv.dbConn.GetAllTasks()
 	if err != nil {
@@ -159,40 +300,39 @@ func (srv *Server) shuffleTasks(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	allAccs, err := srv.dbConn.GetAllAccounts()
+	accs, err := srv.dbConn.GetAllWorkers()
 	if err != nil {
 		log.Println("shuffle get all accs err", err)
 		internalError(w)
 		return
 	}
 
-	var accs []uuid.UUID
-	for _, a := range allAccs {
-		if a.Role == nil || *a.Role == db.Role_Worker {
-			accs = append(accs, a.PublicID)
-		}
-	}
-
 	if len(accs) == 0 || len(allTasks) == 0 {
 		log.Println("nothing to shuffle")
 		http.Redirect(w, r, "/tasks", http.StatusTemporaryRedirect)
 		return
 	}
 
-	for _, t := range allTasks {
-		t.OwnerID = accs[rand.Intn(len(accs))]
-		if err := srv.dbConn.SaveTask(&t); err != nil {
-			log.Println("task save failed", err)
-		} else {
-			go func() {
-				if err := srv.producer.TaskUpdatedMsg(t); err != nil {
-					log.Println("TaskUpdatedMsg", err)
-				}
-				if err := srv.producer.TaskAssignedMsg(t); err != nil {
-					log.Println("TaskAssignedMsg", err)
-				}
-			}()
+	meta := events.Meta{CorrelationID: uuid.NewString()}
+	err = srv.dbConn.WithTx(func(conn db.Connection) error {
+		for _, t := range allTasks {
+			t.OwnerID = accs[rand.Intn(len(accs))]
+			if err := conn.SaveTask(&t); err != nil {
+				return err
+			}
+			if err := srv.producer.TaskUpdatedMsg(conn, meta, t); err != nil {
+				return err
+			}
+			if err := srv.producer.TaskAssignedMsg(conn, meta, t); err != nil {
+				return err
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		log.Println("shuffle failed", err)
+		internalError(w)
+		return
 	}
 
 	http.Redirect(w, r, "/tasks", http.StatusTemporaryRedirect)
@@ -212,20 +352,24 @@ func (srv *Server) updateTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	t, err := srv.dbConn.UpdateTask(vals.Get("public_id"), "", vals.Get("status"))
+	meta := events.Meta{CorrelationID: uuid.NewString()}
+	err := srv.dbConn.WithTx(func(conn db.Connection) error {
+		t, err := conn.UpdateTask(vals.Get("public_id"), "", vals.Get("status"))
+		if err != nil {
+			return err
+		}
+		if err := srv.producer.TaskUpdatedMsg(conn, meta, *t); err != nil {
+			return err
+		}
+		if vals.Get("status") == "done" {
+			return srv.producer.TaskCompletedMsg(conn, meta, *t)
+		}
+		return nil
+	})
 	if err != nil {
 		log.Println("failed to update task", err)
 		internalError(w)
 		return
-	} else {
-		if err := srv.producer.TaskUpdatedMsg(*t); err != nil {
-			log.Println("TaskUpdatedMsg", err)
-		}
-		if vals.Get("status") == "done" {
-			if err := srv.producer.TaskCompletedMsg(*t); err != nil {
-				log.Println("TaskCompletedMsg", err)
-			}
-		}
 	}
 	http.Redirect(w, r, "/tasks", http.StatusTemporaryRedirect)
 }
@@ -246,9 +390,11 @@ func (srv *Server) listTasks(w http.ResponseWriter, r *http.Request) {
 	t.ExecuteTemplate(w, "list", struct {
 		Tasks         []db.Task
 		AllowReassign bool
+		AllowDelete   bool
 	}{
 		Tasks:         allTasks,
-		AllowReassign: *user.Role == db.Role_Admin || *user.Role == db.Role_Manager,
+		AllowReassign: canManageTasks(user),
+		AllowDelete:   canManageTasks(user),
 	})
 }
 
@@ -266,36 +412,41 @@ func (srv *Server) createTask(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	allAccs, err := srv.dbConn.GetAllAccounts()
+	accs, err := srv.dbConn.GetAllWorkers()
 	if err != nil {
 		log.Println("shuffle get all accs err", err)
 		internalError(w)
 		return
 	}
-	var accs []uuid.UUID
-	for _, a := range allAccs {
-		if a.Role == nil || *a.Role == db.Role_Worker {
-			accs = append(accs, a.PublicID)
-		}
-	}
 	if len(accs) == 0 {
 		w.Write([]byte("No workers to assign!"))
 		return
 	}
 
-	t := &db.Task{PublicID: uuid.New(), Description: vals.Get("description"), OwnerID: accs[rand.Intn(len(accs))]}
+	t, err := db.NewTask(vals.Get("title"), vals.Get("jira_id"), vals.Get("description"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	t.OwnerID = accs[rand.Intn(len(accs))]
 
-	if err := srv.dbConn.Create(t); err != nil {
-		log.Println(err)
-	} else {
-		if err := srv.producer.TaskCreatedMsg(*t); err != nil {
-			log.Println("TaskCreatedMsg", err)
+	meta := events.Meta{CorrelationID: uuid.NewString()}
+	log.Println("creating task with vals", vals, "correlation", meta.CorrelationID)
+	err = srv.dbConn.WithTx(func(conn db.Connection) error {
+		if err := conn.CreateTask(t); err != nil {
+			return err
 		}
-		if err := srv.producer.TaskAssignedMsg(*t); err != nil {
-			log.Println("TaskAssignedMsg", err)
+		if err := srv.producer.TaskCreatedMsg(conn, meta, *t); err != nil {
+			return err
 		}
+		return srv.producer.TaskAssignedMsg(conn, meta, *t)
+	})
+	if err != nil {
+		log.Println("failed to create task", err)
+		internalError(w)
+		return
 	}
-	log.Println("creating task with vals", vals)
 	w.Write([]byte("Done!"))
 }
 